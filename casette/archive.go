@@ -0,0 +1,116 @@
+package casette
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Name of the file to load from an archive, empty for the first ROM in it
+var Archive_entry string
+
+// Extensions of files loaded from archives
+var rom_exts = []string{".nes", ".fds", ".nsf", ".nsfe", ".unf", ".unif"}
+
+// Read a ROM file, .zip, .gz and .tar(.gz) archives are extracted in memory
+func readRom(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return readZip(data)
+	case bytes.HasPrefix(data, []byte{0x1F, 0x8B}):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+		if isTar(data) {
+			return readTar(data)
+		}
+		return data, nil
+	case isTar(data):
+		return readTar(data)
+	}
+	return data, nil
+}
+
+// Name of the ROM without the extensions of the file and the archive
+func romBase(path string) string {
+	name := strings.TrimSuffix(path, filepath.Ext(path))
+	if strings.EqualFold(filepath.Ext(name), ".tar") {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+func isTar(data []byte) bool {
+	return len(data) >= 262 && string(data[257:262]) == "ustar"
+}
+
+// Entry to load from an archive
+func isRomEntry(name string) bool {
+	if Archive_entry != "" {
+		return name == Archive_entry || filepath.Base(name) == Archive_entry
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range rom_exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+func readZip(data []byte) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !isRomEntry(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	return nil, noRomEntry()
+}
+
+func readTar(data []byte) ([]byte, error) {
+	r := tar.NewReader(bytes.NewReader(data))
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return nil, noRomEntry()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag == tar.TypeReg && isRomEntry(h.Name) {
+			return ioutil.ReadAll(r)
+		}
+	}
+}
+
+func noRomEntry() error {
+	if Archive_entry != "" {
+		return fmt.Errorf("%s not found in archive", Archive_entry)
+	}
+	return fmt.Errorf("no ROM found in archive")
+}
@@ -0,0 +1,148 @@
+package casette
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+type archiveFile struct {
+	name string
+	data string
+}
+
+func zipImage(t *testing.T, files ...archiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadZip(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []archiveFile
+		entry string
+		want  string
+		err   string
+	}{
+		{"nes", []archiveFile{{"readme.txt", "text"}, {"game.nes", "nes"}}, "", "nes", ""},
+		{"fds", []archiveFile{{"game.FDS", "fds"}, {"manual.pdf", "pdf"}}, "", "fds", ""},
+		{"unf", []archiveFile{{"dir/", ""}, {"dir/game.unf", "unf"}}, "", "unf", ""},
+		{"nsf", []archiveFile{{"music.nsf", "nsf"}}, "", "nsf", ""},
+		{"several", []archiveFile{{"a.nes", "a"}, {"b.nes", "b"}}, "", "a", ""},
+		{"entry", []archiveFile{{"a.nes", "a"}, {"dir/b.nes", "b"}}, "b.nes", "b", ""},
+		{"entry path", []archiveFile{{"a.nes", "a"}, {"dir/b.nes", "b"}}, "dir/b.nes", "b", ""},
+		{"entry text", []archiveFile{{"a.nes", "a"}, {"rom.bin", "bin"}}, "rom.bin", "bin", ""},
+		{"no rom", []archiveFile{{"readme.txt", "text"}}, "", "", "no ROM found in archive"},
+		{"no entry", []archiveFile{{"a.nes", "a"}}, "b.nes", "", "b.nes not found in archive"},
+	}
+	defer func() { Archive_entry = "" }()
+	for _, tt := range tests {
+		Archive_entry = tt.entry
+		got, err := readRom(writeRom(t, "game.zip", zipImage(t, tt.files...)))
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case string(got) != tt.want:
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadGzip(t *testing.T) {
+	rom := inesImage(0, 1, 1)
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(rom)
+	w.Close()
+
+	got, err := readRom(writeRom(t, "game.nes.gz", buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, rom) {
+		t.Errorf("gzip: got %d bytes, want the %d bytes of the ROM", len(got), len(rom))
+	}
+}
+
+func tarImage(t *testing.T, files ...archiveFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, f := range files {
+		h := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(f.name, "/") {
+			h.Typeflag, h.Size = tar.TypeDir, 0
+		}
+		if err := w.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadTar(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []archiveFile
+		entry string
+		want  string
+		err   string
+	}{
+		{"nes", []archiveFile{{"readme.txt", "text"}, {"game.nes", "nes"}}, "", "nes", ""},
+		{"dir", []archiveFile{{"dir/", ""}, {"dir/game.fds", "fds"}}, "", "fds", ""},
+		{"several", []archiveFile{{"a.nes", "a"}, {"b.nes", "b"}}, "", "a", ""},
+		{"entry", []archiveFile{{"a.nes", "a"}, {"dir/b.nes", "b"}}, "b.nes", "b", ""},
+		{"no rom", []archiveFile{{"readme.txt", "text"}}, "", "", "no ROM found in archive"},
+		{"no entry", []archiveFile{{"a.nes", "a"}}, "b.nes", "", "b.nes not found in archive"},
+	}
+	defer func() { Archive_entry = "" }()
+	for _, tt := range tests {
+		Archive_entry = tt.entry
+		image := tarImage(t, tt.files...)
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		w.Write(image)
+		w.Close()
+
+		for _, file := range []archiveFile{{"game.tar", string(image)}, {"game.tar.gz", gz.String()}} {
+			got, err := readRom(writeRom(t, file.name, []byte(file.data)))
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("%s %s: error %v, want %q", file.name, tt.name, err, tt.err)
+				}
+			case err != nil:
+				t.Errorf("%s %s: unexpected error %v", file.name, tt.name, err)
+			case string(got) != tt.want:
+				t.Errorf("%s %s: got %q, want %q", file.name, tt.name, got, tt.want)
+			}
+		}
+	}
+}
@@ -1,32 +1,194 @@
 package casette
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
-	"os"
 )
 
 var Prg_rom []byte
 var Chr_rom []byte
+var Chr_ram []byte
+var Prg_ram []byte
+
+/*
+   Mirroring
+   |-------------+-------|
+   | Mode        | Value |
+   |-------------+-------|
+   | Horizontal  |     0 |
+   | Vertical    |     1 |
+   | Four screen |     2 |
+   | Single A    |     3 |
+   | Single B    |     4 |
+   |-------------+-------|
+*/
+const (
+	MIRROR_HORIZONTAL byte = iota
+	MIRROR_VERTICAL
+	MIRROR_FOUR_SCREEN
+	MIRROR_SINGLE_A
+	MIRROR_SINGLE_B
+)
+
+/*
+   CIRAM page of each nametable
+   |-------------+-------+-------+-------+-------|
+   | Mode        | $2000 | $2400 | $2800 | $2C00 |
+   |-------------+-------+-------+-------+-------|
+   | Horizontal  |     0 |     0 |     1 |     1 |
+   | Vertical    |     0 |     1 |     0 |     1 |
+   | Four screen |     0 |     1 |     2 |     3 |
+   | Single A    |     0 |     0 |     0 |     0 |
+   | Single B    |     1 |     1 |     1 |     1 |
+   |-------------+-------+-------+-------+-------|
+   Pages 2 and 3 are the extra VRAM on four screen boards
+*/
+func mirrorPages(mode byte) [4]byte {
+	switch mode {
+	case MIRROR_VERTICAL:
+		return [4]byte{0, 1, 0, 1}
+	case MIRROR_FOUR_SCREEN:
+		return [4]byte{0, 1, 2, 3}
+	case MIRROR_SINGLE_A:
+		return [4]byte{0, 0, 0, 0}
+	case MIRROR_SINGLE_B:
+		return [4]byte{1, 1, 1, 1}
+	default:
+		return [4]byte{0, 0, 1, 1}
+	}
+}
+
+/*
+   Region
+   |-------+-------|
+   | TV    | Value |
+   |-------+-------|
+   | NTSC  |     0 |
+   | PAL   |     1 |
+   | Multi |     2 |
+   | Dendy |     3 |
+   |-------+-------|
+*/
+const (
+	REGION_NTSC byte = iota
+	REGION_PAL
+	REGION_MULTI
+	REGION_DENDY
+)
+
+// Cartridge information parsed from the ROM header
+type Cartridge struct {
+	Mapper    int
+	Submapper int
+	Mirroring byte
+	Region    byte
+	Battery   bool
+	Trainer   bool
+	Nes2      bool
+	Board     string
+
+	PrgRamSize int
+	ChrRamSize int
+
+	// Game database match of PRG ROM + CHR ROM
+	Crc32       uint32
+	Sha1        string
+	Name        string
+	Known       bool
+	Corrections []string
+
+	// Features of the ROM the emulator doesn't support
+	Warnings []string
+}
+
+var Cart *Cartridge
+
+// Trainer of the iNES ROM, loaded to $7000-$71FF at power-on
+var trainer []byte
 
 // Read ROM and load to CPU/PPU memory
-func SetRom(path string) {
-	f, err := os.Open(path)
+func SetRom(path string) error {
+	rom, err := readRom(path)
+	if err != nil {
+		return err
+	}
+	rom, err = applyPatches(path, rom)
+	if err != nil {
+		return err
+	}
+
+	trainer = nil
+	fds_disk = nil
+	Nsf = nil
+	switch {
+	case isFds(rom):
+		err = loadFds(path, rom)
+	case bytes.HasPrefix(rom, []byte("UNIF")):
+		err = loadUnif(rom)
+	case bytes.HasPrefix(rom, []byte("NESM\x1a")):
+		err = loadNsf(rom)
+	case bytes.HasPrefix(rom, []byte("NSFE")):
+		err = loadNsfe(rom)
+	case bytes.HasPrefix(rom, []byte("NES\x1a")):
+		err = loadInes(rom)
+	default:
+		err = fmt.Errorf("unknown ROM format")
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if fds_disk != nil {
+		err = applyGameDb(Cart, fds_raw)
+	} else {
+		err = applyGameDb(Cart, Prg_rom, Chr_rom)
+	}
+	if err != nil {
+		return err
+	}
+
+	// $6000-$7FFF is always backed by RAM
+	PRG_RAM_SIZE := 0x2000
+	if Cart.PrgRamSize > PRG_RAM_SIZE {
+		PRG_RAM_SIZE = Cart.PrgRamSize
+	}
+	Prg_ram = make([]byte, PRG_RAM_SIZE)
 
+	// Board uses CHR RAM
+	Chr_ram = nil
+	if len(Chr_rom) == 0 {
+		Chr_ram = make([]byte, Cart.ChrRamSize)
+	}
+	Cart_mapper, err = newMapper(Cart)
 	if err != nil {
-		fmt.Printf("Cannot read %s", path)
+		return fmt.Errorf("%s: %v", path, err)
 	}
 
-	defer f.Close()
+	// Trainer is loaded to $7000-$71FF at power-on, a save replaces it
+	copy(Prg_ram[0x1000:], trainer)
 
-	rom, err := ioutil.ReadAll(f)
+	save_path = ""
+	save_encode, save_decode = nil, nil
+	switch {
+	case fds_disk != nil:
+		// Disk writes are kept apart from the image
+		save_encode, save_decode = encodeFdsSave, decodeFdsSave
+		loadSave(path, ".fdssav", fds_disk)
+	case Cart.Battery:
+		loadSave(path, ".sav", Prg_ram)
+	}
+	return nil
+}
 
+// Load iNES and NES 2.0 ROM
+func loadInes(rom []byte) error {
 	/*
 	   Header (16 bytes)
+	   Trainer, if present (0 or 512 bytes)
 	   PRG ROM data (16384 * x bytes)
 	   CHR ROM data, if present (8192 * y bytes)
 	*/
 	HEADER_SIZE := 0x0010
+	TRAINER_SIZE := 0x0200
 	PRG_ROM_SIZE := 0x4000
 	CHR_ROM_SIZE := 0x2000
 
@@ -42,10 +204,138 @@ func SetRom(path string) {
 	   10:    Flags 10 - TV system, PRG-RAM presence (unofficial, rarely used extension)
 	   11-15: Unused padding (should be filled with zero, but some rippers put their name across bytes 7-15)
 	*/
+	if len(rom) < HEADER_SIZE {
+		return fmt.Errorf("ROM is truncated")
+	}
+	Cart = parseHeader(rom[:HEADER_SIZE])
+
 	PRG_ROM_PAGES := int(rom[4])
 	CHR_ROM_PAGES := int(rom[5])
-	CHR_ROM_START := HEADER_SIZE + PRG_ROM_PAGES*PRG_ROM_SIZE
+	if PRG_ROM_PAGES == 0 {
+		return fmt.Errorf("no PRG ROM")
+	}
+	PRG_ROM_START := HEADER_SIZE
+	if Cart.Trainer {
+		PRG_ROM_START += TRAINER_SIZE
+	}
+	CHR_ROM_START := PRG_ROM_START + PRG_ROM_PAGES*PRG_ROM_SIZE
+	if len(rom) < CHR_ROM_START+CHR_ROM_PAGES*CHR_ROM_SIZE {
+		return fmt.Errorf("ROM is truncated")
+	}
 
-	Prg_rom = rom[HEADER_SIZE : HEADER_SIZE+PRG_ROM_PAGES*PRG_ROM_SIZE]
+	Prg_rom = rom[PRG_ROM_START : PRG_ROM_START+PRG_ROM_PAGES*PRG_ROM_SIZE]
 	Chr_rom = rom[CHR_ROM_START : CHR_ROM_START+CHR_ROM_PAGES*CHR_ROM_SIZE]
+	if Cart.Trainer {
+		trainer = rom[HEADER_SIZE : HEADER_SIZE+TRAINER_SIZE]
+	}
+	return nil
+}
+
+/*
+   Flags 6
+   76543210
+   ||||||||
+   |||||||+- Mirroring: 0: horizontal (vertical arrangement) (CIRAM A10 = PPU A11)
+   |||||||              1: vertical (horizontal arrangement) (CIRAM A10 = PPU A10)
+   ||||||+-- 1: Cartridge contains battery-backed PRG RAM ($6000-7FFF) or other persistent memory
+   |||||+--- 1: 512-byte trainer at $7000-$71FF (stored before PRG data)
+   ||||+---- 1: Ignore mirroring control or above mirroring bit; instead provide four-screen VRAM
+   ++++----- Lower nybble of mapper number
+
+   Flags 7
+   76543210
+   ||||||||
+   |||||||+- VS Unisystem
+   ||||||+-- PlayChoice-10
+   ||||++--- If equal to 2, flags 8-15 are in NES 2.0 format
+   ++++----- Upper nybble of mapper number
+
+   Flags 8 (NES 2.0)
+   76543210
+   ||||||||
+   ||||++++- Mapper number D8..D11
+   ++++----- Submapper number
+
+   Flags 9
+   76543210
+   ||||||||
+   |||||||+- TV system (0: NTSC; 1: PAL)
+   +++++++-- Reserved, set to zero
+
+   Byte 10 (NES 2.0)
+   76543210
+   ||||||||
+   ||||++++- PRG-RAM size (volatile) shift count
+   ++++----- PRG-NVRAM/EEPROM size (non-volatile) shift count
+
+   Byte 11 (NES 2.0)
+   76543210
+   ||||||||
+   ||||++++- CHR-RAM size (volatile) shift count
+   ++++----- CHR-NVRAM size (non-volatile) shift count
+   If the shift count is zero, there is no CHR-(NV)RAM.
+   If the shift count is non-zero, the actual size is "64 << shift count" bytes.
+
+   Byte 12 (NES 2.0)
+   76543210
+   ||||||||
+   ||||||++- CPU/PPU timing (0: NTSC; 1: PAL; 2: Multiple-region; 3: Dendy)
+   ++++++--- Reserved
+
+   A dump with garbage in bytes 12-15 of an iNES header (e.g. "DiskDude!")
+   is dirty, bytes 7-10 are ignored then.
+*/
+func parseHeader(header []byte) *Cartridge {
+	cart := new(Cartridge)
+	cart.Nes2 = header[7]&0x0C == 0x08
+	dirty := !cart.Nes2 && (header[12] != 0 || header[13] != 0 || header[14] != 0 || header[15] != 0)
+	if dirty {
+		cart.Corrections = append(cart.Corrections, "dirty header, ignored bytes 7-10")
+	}
+
+	cart.Mapper = int(header[6] >> 4)
+	if !dirty {
+		cart.Mapper |= int(header[7] & 0xF0)
+	}
+	if cart.Nes2 {
+		cart.Mapper |= int(header[8]&0x0F) << 8
+		cart.Submapper = int(header[8] >> 4)
+	}
+
+	switch {
+	case header[6]>>3&0b1 == 1:
+		cart.Mirroring = MIRROR_FOUR_SCREEN
+	case header[6]>>0&0b1 == 1:
+		cart.Mirroring = MIRROR_VERTICAL
+	default:
+		cart.Mirroring = MIRROR_HORIZONTAL
+	}
+	cart.Battery = header[6]>>1&0b1 == 1
+	cart.Trainer = header[6]>>2&0b1 == 1
+
+	cart.PrgRamSize = 0x2000
+	cart.ChrRamSize = 0x2000
+	switch {
+	case cart.Nes2:
+		cart.PrgRamSize = 0
+		if shift := header[10] & 0x0F; shift != 0 {
+			cart.PrgRamSize += 64 << shift
+		}
+		if shift := header[10] >> 4; shift != 0 {
+			cart.PrgRamSize += 64 << shift
+		}
+		if shift := header[11] & 0x0F; shift != 0 {
+			cart.ChrRamSize = 64 << shift
+		} else if shift := header[11] >> 4; shift != 0 {
+			cart.ChrRamSize = 64 << shift
+		}
+		cart.Region = header[12] & 0b11
+	case !dirty:
+		if header[8] != 0 {
+			cart.PrgRamSize = int(header[8]) * 0x2000
+		}
+		cart.Region = header[9] & 0b1
+	}
+
+	return cart
 }
@@ -0,0 +1,149 @@
+package casette
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// iNES image with the mapper number, 16KB PRG ROM units and 8KB CHR ROM units
+func inesImage(mapper int, prg int, chr int) []byte {
+	header := []byte{'N', 'E', 'S', 0x1A, byte(prg), byte(chr), byte(mapper&0x0F) << 4, byte(mapper & 0xF0), 0, 0, 0, 0, 0, 0, 0, 0}
+	return append(header, make([]byte, prg*0x4000+chr*0x2000)...)
+}
+
+// ROM of banks of size bytes filled with the bank number
+func filledBanks(n int, size int) []byte {
+	var data []byte
+	for i := 0; i < n; i++ {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, size)...)
+	}
+	return data
+}
+
+func writeRom(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSetRomMapper(t *testing.T) {
+	tests := []struct {
+		mapper int
+		err    string
+	}{
+		{0, ""},
+		{4, ""},
+		{1, "mapper 1 is not supported"},
+		{255, "mapper 255 is not supported"},
+	}
+	for _, tt := range tests {
+		path := writeRom(t, "game.nes", inesImage(tt.mapper, 2, 1))
+		err := SetRom(path)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("mapper %d: unexpected error %v", tt.mapper, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("mapper %d: error %v, want %q", tt.mapper, err, tt.err)
+		}
+	}
+}
+
+func TestLoadInesError(t *testing.T) {
+	tests := []struct {
+		name string
+		rom  []byte
+		err  string
+	}{
+		{"no PRG ROM", inesImage(0, 0, 1), "no PRG ROM"},
+		{"truncated PRG ROM", inesImage(0, 2, 1)[:0x4010], "ROM is truncated"},
+		{"truncated header", []byte("NES\x1a"), "ROM is truncated"},
+	}
+	for _, tt := range tests {
+		err := SetRom(writeRom(t, "game.nes", tt.rom))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// Mirroring of the header and the mapper registers
+func TestMapperMirroring(t *testing.T) {
+	tests := []struct {
+		name   string
+		mapper int
+		flags  byte
+		addr   uint16
+		data   byte
+		want   [4]byte
+	}{
+		{"horizontal", 0, 0x00, 0, 0, [4]byte{0, 0, 1, 1}},
+		{"vertical", 0, 0x01, 0, 0, [4]byte{0, 1, 0, 1}},
+		{"four screen", 0, 0x08, 0, 0, [4]byte{0, 1, 2, 3}},
+		{"MMC3 vertical", 4, 0x00, 0xA000, 0, [4]byte{0, 1, 0, 1}},
+		// MMC3 can't change four screen boards
+		{"MMC3 four screen", 4, 0x08, 0xA000, 1, [4]byte{0, 1, 2, 3}},
+		{"VRC4 single A", 21, 0x00, 0x9000, 2, [4]byte{0, 0, 0, 0}},
+		{"VRC4 single B", 21, 0x00, 0x9000, 3, [4]byte{1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		rom := inesImage(tt.mapper, 2, 1)
+		rom[6] |= tt.flags
+		if err := SetRom(writeRom(t, "game.nes", rom)); err != nil {
+			t.Fatal(err)
+		}
+		if tt.addr != 0 {
+			Cart_mapper.CpuWrite(tt.addr, tt.data)
+		}
+		if got := Cart_mapper.Mirroring(); got != tt.want {
+			t.Errorf("%s: pages %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// The trainer is loaded to $7000-$71FF and skipped before the PRG ROM
+func TestTrainer(t *testing.T) {
+	tests := []struct {
+		name   string
+		mapper int
+	}{
+		{"NROM", 0},
+		{"MMC3", 4},
+	}
+	for _, tt := range tests {
+		rom := inesImage(tt.mapper, 2, 1)
+		rom[6] |= 0b100
+		prg := filledBanks(4, 0x2000)
+		trainer_data := bytes.Repeat([]byte{0xAA, 0x55}, 0x100)
+		rom = join(rom[:16], trainer_data, prg, rom[16+len(prg):])
+		if err := SetRom(writeRom(t, "game.nes", rom)); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(Prg_rom, prg) {
+			t.Errorf("%s: PRG ROM does not start after the trainer", tt.name)
+		}
+		for addr := uint16(0x7000); addr < 0x7200; addr++ {
+			if got, want := Cart_mapper.CpuRead(addr), trainer_data[addr-0x7000]; got != want {
+				t.Errorf("%s: $%04X $%02X, want $%02X from the trainer", tt.name, addr, got, want)
+				break
+			}
+		}
+		if got := Cart_mapper.CpuRead(0x6FFF) | Cart_mapper.CpuRead(0x7200); got != 0 {
+			t.Errorf("%s: trainer outside $7000-$71FF", tt.name)
+		}
+	}
+
+	// The trainer counts in the ROM size
+	rom := inesImage(0, 2, 1)
+	rom[6] |= 0b100
+	err := SetRom(writeRom(t, "game.nes", rom))
+	if err == nil || !strings.Contains(err.Error(), "ROM is truncated") {
+		t.Errorf("ROM without the trainer bytes: error %v, want \"ROM is truncated\"", err)
+	}
+}
@@ -0,0 +1,583 @@
+package casette
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Path of the FDS BIOS, empty to look for disksys.rom next to the disk image
+// and in the current directory
+var Bios_path string
+
+/*
+   FDS image
+   Header (16 bytes, fwNES only)
+   0-3:   Constant $46 $44 $53 $1A ("FDS" followed by MS-DOS end-of-file)
+   4:     Number of disk sides
+   5-15:  Unused padding
+   Disk sides (65500 * x bytes)
+
+   Blocks of a side
+   |------+--------------+-------------------------------------|
+   | Type | Size         | Notes                               |
+   |------+--------------+-------------------------------------|
+   |    1 | 56           | disk info, "*NINTENDO-HVC*"         |
+   |    2 | 2            | file amount                         |
+   |    3 | 16           | file header, file size at 13-14     |
+   |    4 | 1 + filesize | file data                           |
+   |------+--------------+-------------------------------------|
+   Blocks 3 and 4 repeat for each file
+*/
+const (
+	FDS_HEADER_SIZE = 0x0010
+	FDS_SIDE_SIZE   = 65500
+	FDS_MAPPER      = 20
+)
+
+/*
+   The drive reads a side as a bit stream with gaps between the blocks
+   |----------------+---------+---------------------------------------|
+   | Part           | Bytes   | Notes                                 |
+   |----------------+---------+---------------------------------------|
+   | lead-in gap    | 3537    | 28300 bits of 0                       |
+   | gap end mark   | 1       | $80                                   |
+   | block          |         |                                       |
+   | CRC            | 2       | of the mark and the block, see fdsCrc |
+   | gap            | 122     | 976 bits of 0, before the next mark   |
+   |----------------+---------+---------------------------------------|
+   Tracks are padded to FDS_TRACK_SIZE so games can append files. The save
+   file holds the sides without the gaps, in the format of the image.
+*/
+const (
+	FDS_LEAD_IN    = 28300 / 8
+	FDS_BLOCK_GAP  = 976 / 8
+	FDS_TRACK_SIZE = 0x14000
+)
+
+// Disk sides with gaps, all sides in one slice so it can be saved at once
+var fds_disk []byte
+var fds_sides [][]byte
+
+// Disk sides as in the image, hashed for the game database
+var fds_raw []byte
+
+func isFds(rom []byte) bool {
+	return bytes.HasPrefix(rom, []byte("FDS\x1a")) || bytes.HasPrefix(rom, []byte("\x01*NINTENDO-HVC*"))
+}
+
+// Load FDS disk image and BIOS
+func loadFds(path string, rom []byte) error {
+	if bytes.HasPrefix(rom, []byte("FDS\x1a")) {
+		rom = rom[FDS_HEADER_SIZE:]
+	}
+	if len(rom) < FDS_SIDE_SIZE {
+		return fmt.Errorf("disk image is truncated")
+	}
+
+	bios, err := readBios(path)
+	if err != nil {
+		return err
+	}
+
+	fds_raw = rom[:len(rom)/FDS_SIDE_SIZE*FDS_SIDE_SIZE]
+	fds_disk = nil
+	var sizes []int
+	for side := 0; side+FDS_SIDE_SIZE <= len(rom); side += FDS_SIDE_SIZE {
+		track := addGaps(rom[side : side+FDS_SIDE_SIZE])
+		sizes = append(sizes, len(track))
+		fds_disk = append(fds_disk, track...)
+	}
+	fds_sides = nil
+	for offset, i := 0, 0; i < len(sizes); i++ {
+		fds_sides = append(fds_sides, fds_disk[offset:offset+sizes[i]])
+		offset += sizes[i]
+	}
+
+	Cart = new(Cartridge)
+	Cart.Mapper = FDS_MAPPER
+	Cart.Mirroring = MIRROR_HORIZONTAL
+	Cart.PrgRamSize = 0x8000
+	Cart.ChrRamSize = 0x2000
+	Cart.Board = "FDS"
+
+	Prg_rom = bios
+	Chr_rom = nil
+	return nil
+}
+
+func readBios(path string) ([]byte, error) {
+	paths := []string{Bios_path}
+	if Bios_path == "" {
+		paths = []string{filepath.Join(filepath.Dir(path), "disksys.rom"), "disksys.rom"}
+	}
+	for _, p := range paths {
+		bios, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if len(bios) != 0x2000 {
+			return nil, fmt.Errorf("%s is not an 8 KB FDS BIOS", p)
+		}
+		return bios, nil
+	}
+	return nil, fmt.Errorf("FDS BIOS disksys.rom not found")
+}
+
+// Size of a block of the kind following the blocks in side, 0 when the
+// kind is unknown
+func fdsBlockSize(kind byte, side []byte) int {
+	switch kind {
+	case 1:
+		return 56
+	case 2:
+		return 2
+	case 3:
+		return 16
+	case 4:
+		// file size of the previous file header
+		if n := len(side); n >= 3 {
+			return 1 + (int(side[n-3]) | int(side[n-2])<<8)
+		}
+	}
+	return 0
+}
+
+// Convert a side of the image to the bit stream read by the drive
+func addGaps(side []byte) []byte {
+	track := make([]byte, FDS_LEAD_IN, FDS_TRACK_SIZE)
+
+	for pos := 0; pos < len(side); {
+		size := fdsBlockSize(side[pos], side[:pos])
+		if size == 0 || pos+size > len(side) {
+			break
+		}
+
+		block := append([]byte{0x80}, side[pos:pos+size]...)
+		crc := fdsCrc(block)
+		track = append(track, block...)
+		track = append(track, byte(crc), byte(crc>>8))
+		track = append(track, make([]byte, FDS_BLOCK_GAP)...)
+		pos += size
+	}
+
+	if len(track) < FDS_TRACK_SIZE {
+		track = append(track, make([]byte, FDS_TRACK_SIZE-len(track))...)
+	}
+	return track
+}
+
+// Convert the bit stream of a side back to the image format, a block
+// follows each gap end mark
+func stripGaps(track []byte) []byte {
+	side := make([]byte, 0, FDS_SIDE_SIZE)
+	for pos := 0; pos < len(track); pos++ {
+		if track[pos] == 0 {
+			continue
+		}
+		if track[pos] != 0x80 || pos+1 >= len(track) {
+			break
+		}
+		pos++
+		size := fdsBlockSize(track[pos], side)
+		if size == 0 || pos+size > len(track) || len(side)+size > FDS_SIDE_SIZE {
+			break
+		}
+		side = append(side, track[pos:pos+size]...)
+		// skip the CRC
+		pos += size + 1
+	}
+	return append(side, make([]byte, FDS_SIDE_SIZE-len(side))...)
+}
+
+// Save file of the disk, the sides without gaps
+func encodeFdsSave(disk []byte) []byte {
+	var data []byte
+	for _, track := range fds_sides {
+		data = append(data, stripGaps(track)...)
+	}
+	return data
+}
+
+// Restore the disk sides from the save file
+func decodeFdsSave(data []byte, disk []byte) {
+	for i := range fds_sides {
+		if (i+1)*FDS_SIDE_SIZE > len(data) {
+			break
+		}
+		copy(fds_sides[i], addGaps(data[i*FDS_SIDE_SIZE:(i+1)*FDS_SIDE_SIZE]))
+	}
+}
+
+/*
+   Famicom Disk System RAM adapter (mapper 20)
+   |---------------+-----------------------------------------------|
+   | Address range | Notes                                         |
+   |---------------+-----------------------------------------------|
+   | $4020-$4021   | timer IRQ reload value (low, high)            |
+   | $4022         | timer IRQ control                             |
+   | $4023         | master I/O enable                             |
+   | $4024         | write data                                    |
+   | $4025         | FDS control                                   |
+   | $4030         | disk status (read)                            |
+   | $4031         | read data (read)                              |
+   | $4032         | drive status (read)                           |
+   | $4033         | external connector, battery (read)            |
+   | $6000-$DFFF   | 32 KB PRG RAM                                 |
+   | $E000-$FFFF   | 8 KB BIOS                                     |
+   |---------------+-----------------------------------------------|
+
+   FDS control ($4025)
+   76543210
+   ||||||||
+   |||||||+- Drive motor (0: stop; 1: on)
+   ||||||+-- Transfer reset
+   |||||+--- Read/write mode (0: write; 1: read)
+   ||||+---- Mirroring (0: vertical; 1: horizontal)
+   |||+----- CRC control
+   ||+------ Always 1
+   |+------- Disk ready, start read/write after the gap
+   +-------- Disk IRQ enable
+*/
+type fds struct {
+	baseMapper
+
+	irq_reload  uint16
+	irq_counter uint16
+	irq_repeat  bool
+	irq_enable  bool
+	timer_irq   bool
+
+	disk_enable  bool
+	sound_enable bool
+	control      byte
+	mirroring    byte
+
+	// Drive
+	side          int
+	position      int
+	delay         int
+	scanning      bool
+	end_of_head   bool
+	gap_ended     bool
+	transfer_done bool
+	disk_irq      bool
+	read_data     byte
+	write_data    byte
+	crc           uint16
+	last_crc_ctrl bool
+	insert_delay  int
+	next_side     int
+}
+
+// Side value of an empty drive
+const FDS_NO_DISK = -1
+
+// CPU cycles per byte of the 96.4 kbit/s disk and the head return time
+const (
+	FDS_BYTE_CYCLES   = 150
+	FDS_REWIND_CYCLES = 50000
+)
+
+// CPU cycles the drive stays empty when switching sides
+const FDS_INSERT_CYCLES = 1789773
+
+func newFds() *fds {
+	m := new(fds)
+	m.mirroring = MIRROR_HORIZONTAL
+	m.end_of_head = true
+	return m
+}
+
+func (m *fds) CpuRead(addr uint16) byte {
+	switch {
+	case addr >= 0xE000:
+		return Prg_rom[addr-0xE000]
+	case addr >= 0x6000:
+		return Prg_ram[addr-0x6000]
+	case addr >= 0x4030 && addr <= 0x4033 && m.disk_enable:
+		return m.readRegister(addr)
+	}
+	return byte(addr >> 8)
+}
+
+func (m *fds) readRegister(addr uint16) byte {
+	var data byte
+	switch addr {
+	case 0x4030:
+		if m.timer_irq {
+			data |= 0x01
+		}
+		if m.transfer_done {
+			data |= 0x02
+		}
+		if m.end_of_head {
+			data |= 0x40
+		}
+		if m.disk_enable {
+			data |= 0x80
+		}
+		m.transfer_done = false
+		m.timer_irq = false
+		m.disk_irq = false
+	case 0x4031:
+		data = m.read_data
+		m.transfer_done = false
+		m.disk_irq = false
+	case 0x4032:
+		data = 0x40
+		if m.side == FDS_NO_DISK {
+			data |= 0x07
+		} else if !m.scanning {
+			data |= 0x02
+		}
+	case 0x4033:
+		// battery is good
+		data = 0x80
+	}
+	return data
+}
+
+func (m *fds) CpuWrite(addr uint16, data byte) {
+	switch {
+	case addr >= 0xE000:
+	case addr >= 0x6000:
+		Prg_ram[addr-0x6000] = data
+	case addr == 0x4023:
+		m.disk_enable = data&0b1 == 1
+		m.sound_enable = data>>1&0b1 == 1
+		if !m.disk_enable {
+			m.irq_enable = false
+			m.timer_irq = false
+			m.disk_irq = false
+		}
+	case !m.disk_enable:
+	case addr == 0x4020:
+		m.irq_reload = m.irq_reload&0xFF00 | uint16(data)
+	case addr == 0x4021:
+		m.irq_reload = m.irq_reload&0x00FF | uint16(data)<<8
+	case addr == 0x4022:
+		m.irq_repeat = data&0b1 == 1
+		m.irq_enable = data>>1&0b1 == 1
+		if m.irq_enable {
+			m.irq_counter = m.irq_reload
+		} else {
+			m.timer_irq = false
+		}
+	case addr == 0x4024:
+		m.write_data = data
+		m.transfer_done = false
+		m.disk_irq = false
+	case addr == 0x4025:
+		m.control = data
+		m.disk_irq = false
+		if data>>3&0b1 == 1 {
+			m.mirroring = MIRROR_HORIZONTAL
+		} else {
+			m.mirroring = MIRROR_VERTICAL
+		}
+	}
+}
+
+func (m *fds) PpuRead(addr uint16) byte {
+	return Chr_ram[addr&0x1FFF]
+}
+
+func (m *fds) PpuWrite(addr uint16, data byte) {
+	Chr_ram[addr&0x1FFF] = data
+}
+
+func (m *fds) Mirroring() [4]byte {
+	return mirrorPages(m.mirroring)
+}
+
+func (m *fds) Irq() bool {
+	return m.timer_irq || m.disk_irq
+}
+
+func (m *fds) Clock() {
+	if m.irq_enable && m.disk_enable {
+		if m.irq_counter == 0 {
+			m.timer_irq = true
+			m.irq_counter = m.irq_reload
+			if !m.irq_repeat {
+				m.irq_enable = false
+			}
+		} else {
+			m.irq_counter--
+		}
+	}
+
+	if m.insert_delay > 0 {
+		m.insert_delay--
+		if m.insert_delay == 0 {
+			m.side = m.next_side
+		}
+	}
+	m.clockDisk()
+}
+
+// Move the disk under the head, a byte is transferred every FDS_BYTE_CYCLES
+func (m *fds) clockDisk() {
+	if m.side == FDS_NO_DISK {
+		m.end_of_head = true
+		m.scanning = false
+		m.gap_ended = false
+		return
+	}
+	if m.control&0b1 == 0 {
+		// motor stopped
+		m.end_of_head = true
+		m.scanning = false
+		return
+	}
+	if m.control>>1&0b1 == 1 && !m.scanning {
+		return
+	}
+	if m.end_of_head {
+		m.delay = FDS_REWIND_CYCLES
+		m.end_of_head = false
+		m.position = 0
+		m.gap_ended = false
+		return
+	}
+	if m.delay > 0 {
+		m.delay--
+		return
+	}
+
+	m.scanning = true
+	track := fds_sides[m.side]
+	ready := m.control>>6&0b1 == 1
+	crc_control := m.control>>4&0b1 == 1
+	irq := m.control>>7&0b1 == 1
+
+	if m.control>>2&0b1 == 1 {
+		// Read, the first non-zero byte ends the gap
+		data := track[m.position]
+		if !m.last_crc_ctrl {
+			m.updateCrc(data)
+		}
+		if !ready {
+			m.gap_ended = false
+			m.crc = 0
+		} else if data != 0 && !m.gap_ended {
+			m.gap_ended = true
+			irq = false
+		}
+		if m.gap_ended {
+			m.transfer_done = true
+			m.read_data = data
+			if irq {
+				m.disk_irq = true
+			}
+		}
+	} else {
+		// Write, the CRC is written after the data with CRC control set
+		var data byte
+		if !crc_control {
+			m.transfer_done = true
+			data = m.write_data
+			if irq {
+				m.disk_irq = true
+			}
+		}
+		if !ready {
+			data = 0
+		}
+		if !crc_control {
+			m.updateCrc(data)
+		} else {
+			if !m.last_crc_ctrl {
+				m.updateCrc(0)
+				m.updateCrc(0)
+			}
+			data = byte(m.crc)
+			m.crc >>= 8
+		}
+		track[m.position] = data
+		m.gap_ended = false
+	}
+	m.last_crc_ctrl = crc_control
+
+	m.position++
+	if m.position >= len(track) {
+		m.control &^= 0b1
+		if irq {
+			m.disk_irq = true
+		}
+	} else {
+		m.delay = FDS_BYTE_CYCLES - 1
+	}
+}
+
+func (m *fds) updateCrc(data byte) {
+	m.crc = updateFdsCrc(m.crc, data)
+}
+
+// CRC-16 of the disk blocks (polynomial $8408, LSB first)
+func updateFdsCrc(crc uint16, data byte) uint16 {
+	for n := 0; n < 8; n++ {
+		carry := crc & 0b1
+		crc >>= 1
+		if carry == 1 {
+			crc ^= 0x8408
+		}
+		if data>>n&0b1 == 1 {
+			crc ^= 0x8000
+		}
+	}
+	return crc
+}
+
+// CRC written after a block as the drive does: the gap end mark and the
+// block followed by two zero bytes, stored low byte first
+func fdsCrc(block []byte) uint16 {
+	var crc uint16
+	for _, data := range block {
+		crc = updateFdsCrc(crc, data)
+	}
+	crc = updateFdsCrc(crc, 0)
+	return updateFdsCrc(crc, 0)
+}
+
+// Eject the disk, or insert the selected side when the drive is empty, the
+// status is empty when no FDS image is loaded
+func FdsEject() string {
+	m, ok := Cart_mapper.(*fds)
+	if !ok {
+		return ""
+	}
+	m.insert_delay = 0
+	if m.side == FDS_NO_DISK {
+		m.side = m.next_side
+	} else {
+		m.next_side = m.side
+		m.side = FDS_NO_DISK
+	}
+	return fdsStatus(m)
+}
+
+// Eject the disk and insert the side after the inserted or selected one
+// after FDS_INSERT_CYCLES
+func FdsSwitchSide() string {
+	m, ok := Cart_mapper.(*fds)
+	if !ok {
+		return ""
+	}
+	if m.side != FDS_NO_DISK {
+		m.next_side = m.side
+	}
+	m.next_side = (m.next_side + 1) % len(fds_sides)
+	m.side = FDS_NO_DISK
+	m.insert_delay = FDS_INSERT_CYCLES
+	return fmt.Sprintf("Inserting disk %d side %c", m.next_side/2+1, 'A'+m.next_side%2)
+}
+
+func fdsStatus(m *fds) string {
+	if m.side == FDS_NO_DISK {
+		return "Disk ejected"
+	}
+	return fmt.Sprintf("Disk %d side %c inserted", m.side/2+1, 'A'+m.side%2)
+}
@@ -0,0 +1,227 @@
+package casette
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Side of a disk image with one file of data
+func fdsSide(data []byte) []byte {
+	info := make([]byte, 56)
+	copy(info, "\x01*NINTENDO-HVC*")
+	header := make([]byte, 16)
+	header[0] = 3
+	header[13], header[14] = byte(len(data)), byte(len(data)>>8)
+	side := join(info, []byte{2, 1}, header, []byte{4}, data)
+	return append(side, make([]byte, FDS_SIDE_SIZE-len(side))...)
+}
+
+// Load a disk image of the sides with a BIOS from a temporary file
+func loadTestFds(t *testing.T, sides ...[]byte) string {
+	t.Helper()
+	Bios_path = writeRom(t, "disksys.rom", make([]byte, 0x2000))
+	t.Cleanup(func() { Bios_path = "" })
+	path := writeRom(t, "game.fds", join(sides...))
+	if err := SetRom(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAddGaps(t *testing.T) {
+	side := fdsSide([]byte("DATA"))
+	track := addGaps(side)
+	if len(track) != FDS_TRACK_SIZE {
+		t.Errorf("track of %d bytes, want %d", len(track), FDS_TRACK_SIZE)
+	}
+
+	pos := FDS_LEAD_IN
+	if !bytes.Equal(track[:pos], make([]byte, pos)) {
+		t.Errorf("lead-in is not %d bytes of 0", pos)
+	}
+	sizes := []int{56, 2, 16, 5}
+	in := 0
+	for i, size := range sizes {
+		block := track[pos : pos+1+size]
+		if block[0] != 0x80 || !bytes.Equal(block[1:], side[in:in+size]) {
+			t.Fatalf("block %d at %d is not the gap end mark and block", i, pos)
+		}
+		// The CRC of a block followed by its CRC is 0
+		crc := fdsCrc(track[pos : pos+1+size+2])
+		if crc != 0 || bytes.Equal(track[pos+1+size:pos+3+size], []byte{0, 0}) {
+			t.Errorf("block %d: CRC % X does not check", i, track[pos+1+size:pos+3+size])
+		}
+		pos += 1 + size + 2
+		if !bytes.Equal(track[pos:pos+FDS_BLOCK_GAP], make([]byte, FDS_BLOCK_GAP)) {
+			t.Errorf("block %d: gap is not %d bytes of 0", i, FDS_BLOCK_GAP)
+		}
+		pos += FDS_BLOCK_GAP
+		in += size
+	}
+
+	if got := stripGaps(track); !bytes.Equal(got, side) {
+		t.Errorf("stripGaps doesn't restore the side")
+	}
+}
+
+// The save file holds the sides in the image format
+func TestFdsSave(t *testing.T) {
+	sides := [][]byte{fdsSide([]byte("SIDE A")), fdsSide([]byte("SIDE B"))}
+	path := loadTestFds(t, sides...)
+
+	// A file rewritten by the game, 2 bytes longer
+	written := fdsSide([]byte("WRITTEN!"))
+	copy(fds_sides[1], addGaps(written))
+	if err := FlushSave(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), "game.fdssav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, join(sides[0], written)) {
+		t.Errorf("save file of %d bytes is not the sides without gaps", len(data))
+	}
+
+	if err := SetRom(path); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fds_sides[0], addGaps(sides[0])) || !bytes.Equal(fds_sides[1], addGaps(written)) {
+		t.Errorf("disk not restored from the save file")
+	}
+}
+
+func TestFdsStatus(t *testing.T) {
+	loadTestFds(t, fdsSide(nil))
+	m := Cart_mapper.(*fds)
+
+	// Registers are disabled by $4023
+	if got := m.CpuRead(0x4032); got != 0x40 {
+		t.Errorf("$4032 $%02X with disk I/O disabled, want open bus $40", got)
+	}
+	m.CpuWrite(0x4023, 0x01)
+
+	// Inserted, motor stopped: not ready
+	if got := m.CpuRead(0x4032); got != 0x42 {
+		t.Errorf("$4032 $%02X with the motor stopped, want $42", got)
+	}
+	if got := m.CpuRead(0x4030); got != 0xC0 {
+		t.Errorf("$4030 $%02X with the motor stopped, want end of head $C0", got)
+	}
+
+	// Motor on in read mode, the head leaves the end after rewinding
+	m.CpuWrite(0x4025, 0x25)
+	for i := 0; i < FDS_REWIND_CYCLES+2; i++ {
+		m.Clock()
+	}
+	if got := m.CpuRead(0x4032); got != 0x40 {
+		t.Errorf("$4032 $%02X while scanning, want ready $40", got)
+	}
+
+	// After the gap the mark is transferred without IRQ, then the block
+	m.CpuWrite(0x4025, 0xE5)
+	for i := 0; i < FDS_TRACK_SIZE*FDS_BYTE_CYCLES && !m.transfer_done; i++ {
+		m.Clock()
+	}
+	if got := m.CpuRead(0x4030); got&0x43 != 0x02 || m.Irq() {
+		t.Errorf("$4030 $%02X IRQ %t at the gap end, want byte transferred without IRQ", got, m.Irq())
+	}
+	if got := m.CpuRead(0x4031); got != 0x80 {
+		t.Errorf("$4031 $%02X, want the gap end mark $80", got)
+	}
+	if got := m.CpuRead(0x4030); got&0x02 != 0 {
+		t.Errorf("$4030 $%02X, transfer flag not cleared by the reads", got)
+	}
+	for i := 0; i < FDS_BYTE_CYCLES; i++ {
+		m.Clock()
+	}
+	if !m.Irq() || m.CpuRead(0x4031) != 0x01 {
+		t.Errorf("IRQ %t, want an IRQ for the disk info block $01", m.Irq())
+	}
+
+	FdsEject()
+	if got := m.CpuRead(0x4032); got != 0x47 {
+		t.Errorf("$4032 $%02X without disk, want $47", got)
+	}
+}
+
+func TestFdsTimerIrq(t *testing.T) {
+	loadTestFds(t, fdsSide(nil))
+	m := Cart_mapper.(*fds)
+	m.CpuWrite(0x4023, 0x01)
+	m.CpuWrite(0x4020, 0x03)
+	m.CpuWrite(0x4021, 0x00)
+
+	for _, repeat := range []bool{false, true} {
+		control := byte(0x02)
+		if repeat {
+			control |= 0x01
+		}
+		m.CpuWrite(0x4022, control)
+		for n := 0; n < 2; n++ {
+			// The counter reaches 0 after 3 cycles, the IRQ is raised the next one
+			for i := 0; i < 3; i++ {
+				m.Clock()
+			}
+			if m.Irq() {
+				t.Errorf("repeat %t: IRQ after 3 cycles", repeat)
+			}
+			m.Clock()
+			if m.Irq() != (n == 0 || repeat) {
+				t.Errorf("repeat %t: IRQ %t after %d periods", repeat, m.Irq(), n+1)
+			}
+			// $4030 bit 0 and the acknowledgement
+			if got := m.CpuRead(0x4030); got&0x01 != 0 != (n == 0 || repeat) {
+				t.Errorf("repeat %t: $4030 $%02X after %d periods", repeat, got, n+1)
+			}
+			if m.Irq() {
+				t.Errorf("repeat %t: IRQ not acknowledged by $4030", repeat)
+			}
+		}
+	}
+
+	// Disabling disk I/O stops the timer
+	m.CpuWrite(0x4022, 0x03)
+	m.CpuWrite(0x4023, 0x00)
+	for i := 0; i < 8; i++ {
+		m.Clock()
+	}
+	if m.Irq() {
+		t.Errorf("IRQ with disk I/O disabled")
+	}
+}
+
+func TestFdsSwitchSide(t *testing.T) {
+	loadTestFds(t, fdsSide(nil), fdsSide(nil), fdsSide(nil))
+	m := Cart_mapper.(*fds)
+
+	steps := []struct {
+		name   string
+		action func() string
+		status string
+		side   int
+	}{
+		{"switch", FdsSwitchSide, "Inserting disk 1 side B", FDS_NO_DISK},
+		{"inserted", nil, "", 1},
+		{"eject", FdsEject, "Disk ejected", FDS_NO_DISK},
+		{"insert", FdsEject, "Disk 1 side B inserted", 1},
+		{"eject", FdsEject, "Disk ejected", FDS_NO_DISK},
+		{"switch while empty", FdsSwitchSide, "Inserting disk 2 side A", FDS_NO_DISK},
+		{"switch again", FdsSwitchSide, "Inserting disk 1 side A", FDS_NO_DISK},
+		{"insert now", FdsEject, "Disk 1 side A inserted", 0},
+	}
+	for _, step := range steps {
+		if step.action == nil {
+			for i := 0; i < FDS_INSERT_CYCLES; i++ {
+				m.Clock()
+			}
+		} else if got := step.action(); got != step.status {
+			t.Errorf("%s: status %q, want %q", step.name, got, step.status)
+		}
+		if m.side != step.side {
+			t.Errorf("%s: side %d, want %d", step.name, m.side, step.side)
+		}
+	}
+}
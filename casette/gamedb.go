@@ -0,0 +1,222 @@
+package casette
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+//go:embed gamedb.txt
+var gamedb_txt string
+
+// Entry of the game database, negative values keep the header value
+type gameEntry struct {
+	crc32     uint32
+	has_crc32 bool
+	sha1      string
+	name      string
+
+	mapper    int
+	submapper int
+	mirroring int
+	prg_ram   int
+	chr_ram   int
+	region    int
+}
+
+var game_db []gameEntry
+var game_db_loaded bool
+
+// Add entries of a game database file, they take priority over the
+// embedded database
+func LoadGameDb(path string) error {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	entries, err := parseGameDb(string(text))
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := loadEmbeddedGameDb(); err != nil {
+		return err
+	}
+	game_db = append(entries, game_db...)
+	return nil
+}
+
+func loadEmbeddedGameDb() error {
+	if game_db_loaded {
+		return nil
+	}
+	entries, err := parseGameDb(gamedb_txt)
+	if err != nil {
+		return fmt.Errorf("gamedb.txt: %v", err)
+	}
+	game_db = append(game_db, entries...)
+	game_db_loaded = true
+	return nil
+}
+
+func parseGameDb(text string) ([]gameEntry, error) {
+	var entries []gameEntry
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("line %d: expected at least 8 fields", n)
+		}
+
+		e, err := parseGameEntry(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func parseGameEntry(fields []string) (gameEntry, error) {
+	var e gameEntry
+	var err error
+
+	if fields[0] != "-" {
+		crc, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			return e, fmt.Errorf("bad crc32 %q", fields[0])
+		}
+		e.crc32 = uint32(crc)
+		e.has_crc32 = true
+	}
+	if fields[1] != "-" {
+		if _, err := hex.DecodeString(fields[1]); err != nil || len(fields[1]) != 40 {
+			return e, fmt.Errorf("bad sha1 %q", fields[1])
+		}
+		e.sha1 = strings.ToLower(fields[1])
+	}
+	if !e.has_crc32 && e.sha1 == "" {
+		return e, fmt.Errorf("no hash")
+	}
+
+	if e.mapper, err = parseGameInt(fields[2]); err != nil {
+		return e, err
+	}
+	if e.submapper, err = parseGameInt(fields[3]); err != nil {
+		return e, err
+	}
+	if e.prg_ram, err = parseGameInt(fields[5]); err != nil {
+		return e, err
+	}
+	if e.chr_ram, err = parseGameInt(fields[6]); err != nil {
+		return e, err
+	}
+
+	switch strings.ToUpper(fields[4]) {
+	case "-":
+		e.mirroring = -1
+	case "H":
+		e.mirroring = int(MIRROR_HORIZONTAL)
+	case "V":
+		e.mirroring = int(MIRROR_VERTICAL)
+	case "4":
+		e.mirroring = int(MIRROR_FOUR_SCREEN)
+	case "A":
+		e.mirroring = int(MIRROR_SINGLE_A)
+	case "B":
+		e.mirroring = int(MIRROR_SINGLE_B)
+	default:
+		return e, fmt.Errorf("bad mirroring %q", fields[4])
+	}
+
+	switch strings.ToUpper(fields[7]) {
+	case "-":
+		e.region = -1
+	case "NTSC":
+		e.region = int(REGION_NTSC)
+	case "PAL":
+		e.region = int(REGION_PAL)
+	case "MULTI":
+		e.region = int(REGION_MULTI)
+	case "DENDY":
+		e.region = int(REGION_DENDY)
+	default:
+		return e, fmt.Errorf("bad region %q", fields[7])
+	}
+
+	e.name = strings.Join(fields[8:], " ")
+	return e, nil
+}
+
+func parseGameInt(field string) (int, error) {
+	if field == "-" {
+		return -1, nil
+	}
+	v, err := strconv.ParseInt(field, 0, 32)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("bad number %q", field)
+	}
+	return int(v), nil
+}
+
+// Look up PRG ROM + CHR ROM in the game database
+func lookupGame(crc uint32, sum string) *gameEntry {
+	for i := range game_db {
+		e := &game_db[i]
+		if e.has_crc32 && e.crc32 != crc {
+			continue
+		}
+		if e.sha1 != "" && e.sha1 != sum {
+			continue
+		}
+		return e
+	}
+	return nil
+}
+
+// Hash the ROM data (PRG ROM + CHR ROM, FDS disk sides) and correct the
+// header with the game database
+func applyGameDb(cart *Cartridge, parts ...[]byte) error {
+	if err := loadEmbeddedGameDb(); err != nil {
+		return err
+	}
+	data := bytes.Join(parts, nil)
+	cart.Crc32 = crc32.ChecksumIEEE(data)
+	sum := sha1.Sum(data)
+	cart.Sha1 = hex.EncodeToString(sum[:])
+
+	e := lookupGame(cart.Crc32, cart.Sha1)
+	if e == nil {
+		return nil
+	}
+	cart.Name = e.name
+	cart.Known = true
+
+	correct := func(field string, value *int, db int) {
+		if db >= 0 && *value != db {
+			cart.Corrections = append(cart.Corrections, fmt.Sprintf("%s %d -> %d", field, *value, db))
+			*value = db
+		}
+	}
+	correct("mapper", &cart.Mapper, e.mapper)
+	correct("submapper", &cart.Submapper, e.submapper)
+	correct("PRG RAM size", &cart.PrgRamSize, e.prg_ram)
+	correct("CHR RAM size", &cart.ChrRamSize, e.chr_ram)
+
+	mirroring, region := int(cart.Mirroring), int(cart.Region)
+	correct("mirroring", &mirroring, e.mirroring)
+	correct("region", &region, e.region)
+	cart.Mirroring, cart.Region = byte(mirroring), byte(region)
+	return nil
+}
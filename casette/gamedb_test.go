@@ -0,0 +1,110 @@
+package casette
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func TestParseGameDb(t *testing.T) {
+	tests := []struct {
+		line  string
+		entry gameEntry
+		err   bool
+	}{
+		{
+			line:  "1234ABCD - 4 0 V 0x2000 - PAL Some Game",
+			entry: gameEntry{crc32: 0x1234ABCD, has_crc32: true, name: "Some Game", mapper: 4, submapper: 0, mirroring: int(MIRROR_VERTICAL), prg_ram: 0x2000, chr_ram: -1, region: int(REGION_PAL)},
+		},
+		{
+			line:  "- 0123456789abcdef0123456789ABCDEF01234567 - - 4 - 8192 -",
+			entry: gameEntry{sha1: "0123456789abcdef0123456789abcdef01234567", mapper: -1, submapper: -1, mirroring: int(MIRROR_FOUR_SCREEN), prg_ram: -1, chr_ram: 8192, region: -1},
+		},
+		{line: "- - 4 0 V - - NTSC", err: true},
+		{line: "XYZ - 4 0 V - - NTSC", err: true},
+		{line: "1234ABCD - 4 0 Q - - NTSC", err: true},
+		{line: "1234ABCD - 4 0 V - - MARS", err: true},
+		{line: "1234ABCD - 4 0 V -", err: true},
+	}
+	for _, tt := range tests {
+		entries, err := parseGameDb("# comment\n\n" + tt.line + "\n")
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		if len(entries) != 1 || !reflect.DeepEqual(entries[0], tt.entry) {
+			t.Errorf("%q: got %+v, want %+v", tt.line, entries, tt.entry)
+		}
+	}
+}
+
+func TestApplyGameDb(t *testing.T) {
+	// mapper 0, horizontal mirroring, NTSC in the header
+	rom := inesImage(0, 2, 1)
+	for i := 16; i < len(rom); i++ {
+		rom[i] = byte(i * 7)
+	}
+	crc := crc32.ChecksumIEEE(rom[16:])
+	sum := sha1.Sum(rom[16:])
+
+	tests := []struct {
+		name string
+		db   string
+	}{
+		{"crc32", fmt.Sprintf("%08X - 4 - V - - PAL Test Game\n", crc)},
+		{"sha1", fmt.Sprintf("- %s 4 - V - - PAL Test Game\n", hex.EncodeToString(sum[:]))},
+	}
+	for _, tt := range tests {
+		game_db, game_db_loaded = nil, false
+		if err := LoadGameDb(writeRom(t, "db.txt", []byte(tt.db))); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetRom(writeRom(t, "game.nes", rom)); err != nil {
+			t.Fatal(err)
+		}
+
+		if !Cart.Known || Cart.Name != "Test Game" {
+			t.Errorf("%s: game not found, name %q", tt.name, Cart.Name)
+		}
+		if Cart.Crc32 != crc || Cart.Sha1 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: hashes %08X %s", tt.name, Cart.Crc32, Cart.Sha1)
+		}
+		if Cart.Mapper != 4 || Cart.Mirroring != MIRROR_VERTICAL || Cart.Region != REGION_PAL {
+			t.Errorf("%s: mapper %d mirroring %d region %d", tt.name, Cart.Mapper, Cart.Mirroring, Cart.Region)
+		}
+		want := []string{"mapper 0 -> 4", fmt.Sprintf("mirroring %d -> %d", MIRROR_HORIZONTAL, MIRROR_VERTICAL), fmt.Sprintf("region %d -> %d", REGION_NTSC, REGION_PAL)}
+		if !reflect.DeepEqual(Cart.Corrections, want) {
+			t.Errorf("%s: corrections %q, want %q", tt.name, Cart.Corrections, want)
+		}
+	}
+	game_db, game_db_loaded = nil, false
+}
+
+func TestEmbeddedGameDb(t *testing.T) {
+	game_db, game_db_loaded = nil, false
+	if err := loadEmbeddedGameDb(); err != nil {
+		t.Errorf("embedded database: %v", err)
+	}
+
+	// A broken database fails the ROM load instead of the emulator
+	embedded := gamedb_txt
+	gamedb_txt = "1234ABCD - 4 0 V -\n"
+	game_db, game_db_loaded = nil, false
+	if err := SetRom(writeRom(t, "game.nes", inesImage(0, 2, 1))); err == nil {
+		t.Errorf("ROM loaded with a broken embedded database")
+	}
+	if err := LoadGameDb(writeRom(t, "db.txt", nil)); err == nil {
+		t.Errorf("-gamedb loaded with a broken embedded database")
+	}
+	gamedb_txt = embedded
+	game_db, game_db_loaded = nil, false
+}
@@ -0,0 +1,182 @@
+package casette
+
+import "fmt"
+
+/*
+   Mapper
+   |-----------+---------------+----------------------------------------------|
+   | Method    | Address range | Notes                                        |
+   |-----------+---------------+----------------------------------------------|
+   | CpuRead   | $4020-$FFFF   | PRG ROM, PRG RAM and mapper registers        |
+   | CpuWrite  | $2000-$FFFF   | also sees PPU register writes $2000-$2007    |
+   | PpuRead   | $0000-$1FFF   | pattern tables (CHR ROM/RAM)                 |
+   | PpuWrite  | $0000-$1FFF   | CHR RAM only                                 |
+   | PpuAddr   | $0000-$3FFF   | every address the PPU puts on its bus        |
+   | Irq       |               | state of the cartridge /IRQ line             |
+   | Clock     |               | called once per CPU cycle                    |
+   | Mirroring | $2000-$3EFF   | CIRAM page of each nametable                 |
+   |-----------+---------------+----------------------------------------------|
+*/
+type Mapper interface {
+	CpuRead(addr uint16) byte
+	CpuWrite(addr uint16, data byte)
+	PpuRead(addr uint16) byte
+	PpuWrite(addr uint16, data byte)
+	PpuAddr(addr uint16)
+	Irq() bool
+	Clock()
+	Mirroring() [4]byte
+}
+
+var Cart_mapper Mapper
+
+// CPU cycles (M2) at the current PPU dot, advanced by the PPU so mappers
+// watching the PPU bus can measure time in CPU cycles. The CPU clocks the
+// mapper for a whole instruction before the PPU catches up, so Clock can't
+// be used to time PPU bus events.
+var Ppu_m2 uint64
+
+// Mappers supplying nametable data themselves (MMC5 ExRAM and fill mode)
+// implement NametableMapper, ok false falls back to the PPU VRAM
+type NametableMapper interface {
+	NtRead(addr uint16) (data byte, ok bool)
+	NtWrite(addr uint16, data byte) (ok bool)
+}
+
+// Mappers with expansion audio implement Audio
+type Audio interface {
+	// Output level of the expansion channels in 0.0-1.0
+	Output() float32
+}
+
+// Expansion audio output to be mixed with the APU, 0 if the board has none
+func ExpansionAudio() float32 {
+	if audio, ok := Cart_mapper.(Audio); ok {
+		return audio.Output()
+	}
+	return 0
+}
+
+// Create mapper by mapper number
+func newMapper(cart *Cartridge) (Mapper, error) {
+	switch cart.Mapper {
+	case 0:
+		return newNrom(), nil
+	case 2:
+		return newUxrom(), nil
+	case 4:
+		return newMmc3(cart.Submapper), nil
+	case 5:
+		return newMmc5(), nil
+	case 9:
+		return newMmc2(false), nil
+	case 10:
+		return newMmc2(true), nil
+	case FDS_MAPPER:
+		return newFds(), nil
+	case NSF_MAPPER:
+		return newNsfPlayer(), nil
+	case 21, 22, 23, 25:
+		return newVrc(cart.Mapper, cart.Submapper), nil
+	case 24, 26:
+		return newVrc6(cart.Mapper), nil
+	case 85:
+		return newVrc7(cart.Submapper), nil
+	default:
+		return nil, fmt.Errorf("mapper %d is not supported", cart.Mapper)
+	}
+}
+
+// Default behaviour shared by all mappers
+type baseMapper struct{}
+
+func (m *baseMapper) PpuAddr(addr uint16) {}
+
+func (m *baseMapper) Irq() bool {
+	return false
+}
+
+func (m *baseMapper) Clock() {}
+
+// Mirroring from the header
+func (m *baseMapper) Mirroring() [4]byte {
+	return mirrorPages(Cart.Mirroring)
+}
+
+// Index in mem of bank of size bytes, negative bank counts from the last bank
+func bankIndex(mem []byte, bank int, size int, offset uint16) int {
+	banks := len(mem) / size
+	if banks == 0 {
+		return int(offset) % len(mem)
+	}
+	if bank < 0 {
+		bank += banks
+	}
+	bank %= banks
+	return bank*size + int(offset)%size
+}
+
+// Read bank of size bytes from rom
+func readBank(rom []byte, bank int, size int, offset uint16) byte {
+	if len(rom) == 0 {
+		return 0
+	}
+	return rom[bankIndex(rom, bank, size, offset)]
+}
+
+// Read CHR ROM, or CHR RAM on boards without CHR ROM
+func readChr(bank int, size int, offset uint16) byte {
+	if len(Chr_ram) > 0 {
+		return readBank(Chr_ram, bank, size, offset)
+	}
+	return readBank(Chr_rom, bank, size, offset)
+}
+
+// Write CHR RAM, writes to CHR ROM are ignored
+func writeChr(bank int, size int, offset uint16, data byte) {
+	if len(Chr_ram) > 0 {
+		Chr_ram[bankIndex(Chr_ram, bank, size, offset)] = data
+	}
+}
+
+/*
+   NROM (mapper 0)
+   |---------------+----------------------------------------------|
+   | Address range | Device                                       |
+   |---------------+----------------------------------------------|
+   | $6000-$7FFF   | PRG RAM (Family Basic only)                  |
+   | $8000-$BFFF   | First 16 KB of ROM                           |
+   | $C000-$FFFF   | Last 16 KB of ROM or mirror of $8000-$BFFF   |
+   |---------------+----------------------------------------------|
+*/
+type nrom struct {
+	baseMapper
+}
+
+func newNrom() *nrom {
+	return new(nrom)
+}
+
+func (m *nrom) CpuRead(addr uint16) byte {
+	switch {
+	case addr >= 0x8000:
+		return Prg_rom[int(addr-0x8000)%len(Prg_rom)]
+	case addr >= 0x6000:
+		return Prg_ram[addr-0x6000]
+	}
+	return byte(addr >> 8)
+}
+
+func (m *nrom) CpuWrite(addr uint16, data byte) {
+	if addr >= 0x6000 && addr < 0x8000 {
+		Prg_ram[addr-0x6000] = data
+	}
+}
+
+func (m *nrom) PpuRead(addr uint16) byte {
+	return readChr(0, 0x2000, addr)
+}
+
+func (m *nrom) PpuWrite(addr uint16, data byte) {
+	writeChr(0, 0x2000, addr, data)
+}
@@ -0,0 +1,116 @@
+package casette
+
+/*
+   MMC2 (mapper 9) / MMC4 (mapper 10)
+   |---------------+-------+----------------------------------------------|
+   | Address range | Bank  | Notes                                        |
+   |---------------+-------+----------------------------------------------|
+   | $6000-$7FFF   |       | MMC4: PRG RAM                                |
+   | $8000-$9FFF   |  8 KB | MMC2: $A000                                  |
+   | $A000-$FFFF   |  8 KB | MMC2: last three banks                       |
+   | $8000-$BFFF   | 16 KB | MMC4: $A000                                  |
+   | $C000-$FFFF   | 16 KB | MMC4: last bank                              |
+   |---------------+-------+----------------------------------------------|
+
+   Registers
+   |-------------+------------------------------------------|
+   | Address     | Notes                                    |
+   |-------------+------------------------------------------|
+   | $A000-$AFFF | PRG bank                                 |
+   | $B000-$BFFF | CHR bank $0000-$0FFF when latch 0 is $FD |
+   | $C000-$CFFF | CHR bank $0000-$0FFF when latch 0 is $FE |
+   | $D000-$DFFF | CHR bank $1000-$1FFF when latch 1 is $FD |
+   | $E000-$EFFF | CHR bank $1000-$1FFF when latch 1 is $FE |
+   | $F000-$FFFF | mirroring (0: vertical, 1: horizontal)   |
+   |-------------+------------------------------------------|
+
+   Latches are set after the PPU fetches tile $FD or $FE
+   |-------------+------------------+-----------------|
+   | Latch       | MMC2             | MMC4            |
+   |-------------+------------------+-----------------|
+   | 0 = $FD     | $0FD8            | $0FD8-$0FDF     |
+   | 0 = $FE     | $0FE8            | $0FE8-$0FEF     |
+   | 1 = $FD     | $1FD8-$1FDF      | $1FD8-$1FDF     |
+   | 1 = $FE     | $1FE8-$1FEF      | $1FE8-$1FEF     |
+   |-------------+------------------+-----------------|
+*/
+type mmc2 struct {
+	baseMapper
+	mmc4 bool
+
+	prg_bank  byte
+	chr_banks [2][2]byte
+	latches   [2]byte
+	mirroring byte
+}
+
+func newMmc2(mmc4 bool) *mmc2 {
+	m := new(mmc2)
+	m.mmc4 = mmc4
+	m.latches = [2]byte{0xFE, 0xFE}
+	m.mirroring = Cart.Mirroring
+	return m
+}
+
+func (m *mmc2) CpuRead(addr uint16) byte {
+	switch {
+	case addr >= 0x8000 && m.mmc4:
+		if addr < 0xC000 {
+			return readBank(Prg_rom, int(m.prg_bank&0x0F), 0x4000, addr)
+		}
+		return readBank(Prg_rom, -1, 0x4000, addr)
+	case addr >= 0x8000:
+		if addr < 0xA000 {
+			return readBank(Prg_rom, int(m.prg_bank&0x0F), 0x2000, addr)
+		}
+		return readBank(Prg_rom, int(addr-0xA000)/0x2000-3, 0x2000, addr)
+	case addr >= 0x6000 && m.mmc4:
+		return Prg_ram[addr-0x6000]
+	}
+	return byte(addr >> 8)
+}
+
+func (m *mmc2) CpuWrite(addr uint16, data byte) {
+	switch {
+	case addr >= 0xF000:
+		m.mirroring = MIRROR_VERTICAL - data&0b1
+	case addr >= 0xB000:
+		reg := (addr - 0xB000) >> 12
+		m.chr_banks[reg/2][reg%2] = data & 0x1F
+	case addr >= 0xA000:
+		m.prg_bank = data
+	case addr >= 0x6000 && addr < 0x8000 && m.mmc4:
+		Prg_ram[addr-0x6000] = data
+	}
+}
+
+func (m *mmc2) chrBank(addr uint16) int {
+	table := addr >> 12 & 0b1
+	if m.latches[table] == 0xFE {
+		return int(m.chr_banks[table][1])
+	}
+	return int(m.chr_banks[table][0])
+}
+
+func (m *mmc2) PpuRead(addr uint16) byte {
+	table := addr >> 12 & 0b1
+	data := readChr(m.chrBank(addr), 0x1000, addr)
+
+	// Update latch after the fetch, MMC2 latch 0 only reacts to one address
+	exact := table == 0 && !m.mmc4
+	switch {
+	case addr&0x0FF8 == 0x0FD8 && (!exact || addr == 0x0FD8):
+		m.latches[table] = 0xFD
+	case addr&0x0FF8 == 0x0FE8 && (!exact || addr == 0x0FE8):
+		m.latches[table] = 0xFE
+	}
+	return data
+}
+
+func (m *mmc2) PpuWrite(addr uint16, data byte) {
+	writeChr(m.chrBank(addr), 0x1000, addr, data)
+}
+
+func (m *mmc2) Mirroring() [4]byte {
+	return mirrorPages(m.mirroring)
+}
@@ -0,0 +1,49 @@
+package casette
+
+import "testing"
+
+// Tiles $FD and $FE switch the CHR banks after they are fetched
+func TestMmc2Latch(t *testing.T) {
+	tests := []struct {
+		mmc4  bool
+		fetch uint16
+		// CHR banks of $0000 and $1000 after the fetch
+		want [2]byte
+	}{
+		{false, 0x0FD9, [2]byte{2, 4}},
+		{false, 0x0FD8, [2]byte{1, 4}},
+		{false, 0x0FEF, [2]byte{1, 4}},
+		{false, 0x0FE8, [2]byte{2, 4}},
+		{false, 0x1FDF, [2]byte{2, 3}},
+		{false, 0x1FD0, [2]byte{2, 3}},
+		{false, 0x1FE8, [2]byte{2, 4}},
+		{false, 0x1FD8, [2]byte{2, 3}},
+		{true, 0x0FDA, [2]byte{1, 4}},
+		{true, 0x0FEF, [2]byte{2, 4}},
+		{true, 0x1FDD, [2]byte{2, 3}},
+		{true, 0x1FEB, [2]byte{2, 4}},
+	}
+	var m *mmc2
+	for i, tt := range tests {
+		if i == 0 || tests[i-1].mmc4 != tt.mmc4 {
+			Cart = &Cartridge{Mapper: 9}
+			Prg_rom = filledBanks(16, 0x2000)
+			Chr_rom = filledBanks(32, 0x1000)
+			Chr_ram = nil
+			m = newMmc2(tt.mmc4)
+			for reg, bank := range []byte{1, 2, 3, 4} {
+				m.CpuWrite(0xB000+uint16(reg)*0x1000, bank)
+			}
+		}
+
+		// The fetch itself still reads the bank before the switch
+		before := m.chrBank(tt.fetch)
+		if got := m.PpuRead(tt.fetch); got != byte(before) {
+			t.Errorf("MMC4 %t $%04X: fetched bank %d, want %d", tt.mmc4, tt.fetch, got, before)
+		}
+		got := [2]byte{m.PpuRead(0x0000), m.PpuRead(0x1000)}
+		if got != tt.want {
+			t.Errorf("MMC4 %t $%04X: CHR banks %v, want %v", tt.mmc4, tt.fetch, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package casette
+
+/*
+   MMC3 (mapper 4)
+   |---------------+------+--------------------------------------------|
+   | Address range | Bank | Notes                                      |
+   |---------------+------+--------------------------------------------|
+   | $6000-$7FFF   |      | 8 KB PRG RAM (MMC6: 1 KB at $7000-$73FF)   |
+   | $8000-$9FFF   | 8 KB | R6 or second last bank (PRG mode)          |
+   | $A000-$BFFF   | 8 KB | R7                                         |
+   | $C000-$DFFF   | 8 KB | second last bank or R6 (PRG mode)          |
+   | $E000-$FFFF   | 8 KB | last bank                                  |
+   |---------------+------+--------------------------------------------|
+
+   Registers (even/odd address)
+   |-------------+---------------------+--------------------|
+   | Address     | Even                | Odd                |
+   |-------------+---------------------+--------------------|
+   | $8000-$9FFF | bank select         | bank data          |
+   | $A000-$BFFF | mirroring           | PRG RAM protect    |
+   | $C000-$DFFF | IRQ latch           | IRQ reload         |
+   | $E000-$FFFF | IRQ disable and ack | IRQ enable         |
+   |-------------+---------------------+--------------------|
+*/
+type mmc3 struct {
+	baseMapper
+	bank_select byte
+	banks       [8]byte
+	mirroring   byte
+	ram_protect byte
+
+	irq_latch   byte
+	irq_counter byte
+	irq_reload  bool
+	irq_enable  bool
+	irq_flag    bool
+
+	// A12 state and the M2 cycle it went low, used to filter the rising edges
+	a12_high bool
+	a12_fall uint64
+
+	// submapper 1: MMC6, submapper 4: MMC3A (old IRQ behaviour)
+	mmc6    bool
+	old_irq bool
+}
+
+// CPU cycles (M2 falling edges) A12 has to stay low before a rising edge
+// clocks the IRQ counter. The garbage nametable fetches between sprite
+// pattern fetches keep it low for 4 dots, about 1.3 CPU cycles.
+const MMC3_A12_FILTER = 3
+
+func newMmc3(submapper int) *mmc3 {
+	m := new(mmc3)
+	m.mmc6 = submapper == 1
+	m.old_irq = submapper == 4
+	m.mirroring = Cart.Mirroring
+	// Games that never write $A001 expect the PRG RAM to be enabled
+	if !m.mmc6 {
+		m.ram_protect = 0x80
+	}
+	return m
+}
+
+func (m *mmc3) prgBank(addr uint16) int {
+	slot := int(addr-0x8000) / 0x2000
+	if m.bank_select>>6&0b1 == 1 && slot != 1 {
+		slot = 2 - slot
+	}
+	switch slot {
+	case 0:
+		return int(m.banks[6] & 0x3F)
+	case 1:
+		return int(m.banks[7] & 0x3F)
+	case 2:
+		return -2
+	default:
+		return -1
+	}
+}
+
+func (m *mmc3) chrBank(addr uint16) int {
+	if m.bank_select>>7&0b1 == 1 {
+		addr ^= 0x1000
+	}
+	slot := int(addr / 0x0400)
+	switch {
+	case slot < 2:
+		return int(m.banks[0]&0xFE) + slot
+	case slot < 4:
+		return int(m.banks[1]&0xFE) + slot - 2
+	default:
+		return int(m.banks[slot-2])
+	}
+}
+
+func (m *mmc3) CpuRead(addr uint16) byte {
+	switch {
+	case addr >= 0x8000:
+		return readBank(Prg_rom, m.prgBank(addr), 0x2000, addr)
+	case addr >= 0x6000:
+		if m.mmc6 {
+			return m.readMmc6Ram(addr)
+		}
+		if m.ram_protect>>7&0b1 == 1 {
+			return Prg_ram[addr-0x6000]
+		}
+	}
+	return byte(addr >> 8)
+}
+
+func (m *mmc3) CpuWrite(addr uint16, data byte) {
+	switch {
+	case addr >= 0x8000:
+		m.writeRegister(addr, data)
+	case addr >= 0x6000:
+		if m.mmc6 {
+			m.writeMmc6Ram(addr, data)
+		} else if m.ram_protect>>6&0b11 == 0b10 {
+			Prg_ram[addr-0x6000] = data
+		}
+	}
+}
+
+func (m *mmc3) writeRegister(addr uint16, data byte) {
+	even := addr&0b1 == 0
+	switch {
+	case addr < 0xA000 && even:
+		m.bank_select = data
+		if m.mmc6 && data>>5&0b1 == 0 {
+			m.ram_protect = 0
+		}
+	case addr < 0xA000:
+		m.banks[m.bank_select&0b111] = data
+	case addr < 0xC000 && even:
+		if Cart.Mirroring != MIRROR_FOUR_SCREEN {
+			m.mirroring = MIRROR_VERTICAL - data&0b1
+		}
+	case addr < 0xC000:
+		if !m.mmc6 || m.bank_select>>5&0b1 == 1 {
+			m.ram_protect = data
+		}
+	case addr < 0xE000 && even:
+		m.irq_latch = data
+	case addr < 0xE000:
+		m.irq_counter = 0
+		m.irq_reload = true
+	case even:
+		m.irq_enable = false
+		m.irq_flag = false
+	default:
+		m.irq_enable = true
+	}
+}
+
+/*
+   MMC6 PRG RAM protect ($A001)
+   |-----+-------------------------------|
+   | bit | Notes                         |
+   |-----+-------------------------------|
+   |   7 | enable reading $7200-$73FF    |
+   |   6 | enable writing $7200-$73FF    |
+   |   5 | enable reading $7000-$71FF    |
+   |   4 | enable writing $7000-$71FF    |
+   |-----+-------------------------------|
+*/
+func (m *mmc3) readMmc6Ram(addr uint16) byte {
+	if addr < 0x7000 || m.ram_protect&0b10100000 == 0 {
+		return byte(addr >> 8)
+	}
+	offset := addr & 0x03FF
+	shift := 5
+	if offset >= 0x0200 {
+		shift = 7
+	}
+	if m.ram_protect>>shift&0b1 == 0 {
+		return 0
+	}
+	return Prg_ram[offset]
+}
+
+func (m *mmc3) writeMmc6Ram(addr uint16, data byte) {
+	if addr < 0x7000 {
+		return
+	}
+	offset := addr & 0x03FF
+	shift := 4
+	if offset >= 0x0200 {
+		shift = 6
+	}
+	if m.ram_protect>>shift&0b11 == 0b11 {
+		Prg_ram[offset] = data
+	}
+}
+
+func (m *mmc3) PpuRead(addr uint16) byte {
+	return readChr(m.chrBank(addr), 0x0400, addr)
+}
+
+func (m *mmc3) PpuWrite(addr uint16, data byte) {
+	writeChr(m.chrBank(addr), 0x0400, addr, data)
+}
+
+// Clock IRQ counter on filtered rising edges of PPU A12
+func (m *mmc3) PpuAddr(addr uint16) {
+	if addr&0x1000 == 0 {
+		if m.a12_high {
+			m.a12_high = false
+			m.a12_fall = Ppu_m2
+		}
+		return
+	}
+	if !m.a12_high && Ppu_m2-m.a12_fall >= MMC3_A12_FILTER {
+		m.clockIrq()
+	}
+	m.a12_high = true
+}
+
+func (m *mmc3) clockIrq() {
+	before := m.irq_counter
+	if m.irq_counter == 0 || m.irq_reload {
+		m.irq_counter = m.irq_latch
+	} else {
+		m.irq_counter--
+	}
+
+	if m.irq_counter == 0 && m.irq_enable {
+		// MMC3A only fires when the counter was decremented or reloaded to 0
+		if !m.old_irq || before != 0 || m.irq_reload {
+			m.irq_flag = true
+		}
+	}
+	m.irq_reload = false
+}
+
+func (m *mmc3) Irq() bool {
+	return m.irq_flag
+}
+
+func (m *mmc3) Mirroring() [4]byte {
+	return mirrorPages(m.mirroring)
+}
@@ -0,0 +1,154 @@
+package casette
+
+import "testing"
+
+func newTestMmc3(submapper int) *mmc3 {
+	Cart = &Cartridge{Mapper: 4, Submapper: submapper}
+	Prg_rom = filledBanks(16, 0x2000)
+	Prg_ram = make([]byte, 0x2000)
+	Chr_rom = filledBanks(64, 0x0400)
+	Chr_ram = nil
+	Ppu_m2 = 0
+	return newMmc3(submapper)
+}
+
+// Rising edge of A12 after it stayed low for the cycles
+func mmc3Edge(m *mmc3, low uint64) {
+	m.PpuAddr(0x0000)
+	Ppu_m2 += low
+	m.PpuAddr(0x1000)
+	Ppu_m2++
+}
+
+func TestMmc3PrgMode(t *testing.T) {
+	m := newTestMmc3(0)
+	m.CpuWrite(0x8000, 6)
+	m.CpuWrite(0x8001, 3)
+	m.CpuWrite(0x8000, 7)
+	m.CpuWrite(0x8001, 5)
+	tests := []struct {
+		bank_select byte
+		want        []byte
+	}{
+		{0x07, []byte{3, 5, 14, 15}},
+		{0x47, []byte{14, 5, 3, 15}},
+	}
+	for _, tt := range tests {
+		m.CpuWrite(0x8000, tt.bank_select)
+		for i, w := range tt.want {
+			if got := m.CpuRead(0x8000 + uint16(i)*0x2000); got != w {
+				t.Errorf("bank select $%02X $%04X: bank %d, want %d", tt.bank_select, 0x8000+i*0x2000, got, w)
+			}
+		}
+	}
+}
+
+func TestMmc3ChrMode(t *testing.T) {
+	m := newTestMmc3(0)
+	// R0 and R1 ignore the lowest bit
+	for r, bank := range []byte{9, 12, 20, 21, 22, 23} {
+		m.CpuWrite(0x8000, byte(r))
+		m.CpuWrite(0x8001, bank)
+	}
+	tests := []struct {
+		bank_select byte
+		want        [8]byte
+	}{
+		{0x00, [8]byte{8, 9, 12, 13, 20, 21, 22, 23}},
+		{0x80, [8]byte{20, 21, 22, 23, 8, 9, 12, 13}},
+	}
+	for _, tt := range tests {
+		m.CpuWrite(0x8000, tt.bank_select)
+		var got [8]byte
+		for i := range got {
+			got[i] = m.PpuRead(uint16(i) * 0x0400)
+		}
+		if got != tt.want {
+			t.Errorf("bank select $%02X: CHR banks %v, want %v", tt.bank_select, got, tt.want)
+		}
+	}
+}
+
+func TestMmc3RamProtect(t *testing.T) {
+	m := newTestMmc3(0)
+	// Enabled before the first $A001 write
+	m.CpuWrite(0x6000, 0x5A)
+	if got := m.CpuRead(0x6000); got != 0x5A {
+		t.Errorf("default: PRG RAM $%02X, want $5A", got)
+	}
+
+	tests := []struct {
+		protect byte
+		data    byte
+		want    byte
+	}{
+		{0xC0, 0x11, 0x5A},
+		{0x00, 0x22, 0x60},
+		{0x80, 0x33, 0x33},
+	}
+	for _, tt := range tests {
+		m.CpuWrite(0xA001, tt.protect)
+		m.CpuWrite(0x6000, tt.data)
+		if got := m.CpuRead(0x6000); got != tt.want {
+			t.Errorf("$A001 $%02X: read $%02X, want $%02X", tt.protect, got, tt.want)
+		}
+	}
+}
+
+func TestMmc3Irq(t *testing.T) {
+	m := newTestMmc3(0)
+	m.CpuWrite(0xC000, 2)
+	m.CpuWrite(0xC001, 0)
+	m.CpuWrite(0xE001, 0)
+
+	// A12 low for fewer cycles than the filter, between sprite fetches
+	mmc3Edge(m, MMC3_A12_FILTER)
+	for i := 0; i < 4; i++ {
+		mmc3Edge(m, MMC3_A12_FILTER-2)
+	}
+	if m.irq_counter != 2 {
+		t.Errorf("counter %d after the filtered edges, want 2", m.irq_counter)
+	}
+
+	mmc3Edge(m, MMC3_A12_FILTER)
+	if m.Irq() {
+		t.Errorf("IRQ at counter %d", m.irq_counter)
+	}
+	mmc3Edge(m, MMC3_A12_FILTER)
+	if !m.Irq() {
+		t.Errorf("no IRQ at counter %d", m.irq_counter)
+	}
+
+	// $E000 acknowledges and disables
+	m.CpuWrite(0xE000, 0)
+	mmc3Edge(m, MMC3_A12_FILTER)
+	mmc3Edge(m, MMC3_A12_FILTER)
+	if m.Irq() {
+		t.Errorf("IRQ after $E000")
+	}
+}
+
+// Latch 0 fires on every scanline, except on MMC3A after the first reload
+func TestMmc3IrqLatchZero(t *testing.T) {
+	tests := []struct {
+		submapper int
+		want      []bool
+	}{
+		{0, []bool{true, true, true}},
+		{4, []bool{true, false, false}},
+	}
+	for _, tt := range tests {
+		m := newTestMmc3(tt.submapper)
+		m.CpuWrite(0xC000, 0)
+		m.CpuWrite(0xC001, 0)
+		m.CpuWrite(0xE001, 0)
+		for i, want := range tt.want {
+			mmc3Edge(m, MMC3_A12_FILTER)
+			if m.Irq() != want {
+				t.Errorf("submapper %d: scanline %d IRQ %t, want %t", tt.submapper, i, m.Irq(), want)
+			}
+			m.CpuWrite(0xE000, 0)
+			m.CpuWrite(0xE001, 0)
+		}
+	}
+}
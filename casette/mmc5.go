@@ -0,0 +1,635 @@
+package casette
+
+/*
+   MMC5 (mapper 5)
+   |-------------+-----------------------------------------------|
+   | Address     | Notes                                         |
+   |-------------+-----------------------------------------------|
+   | $5000-$5015 | audio (pulse 1, pulse 2, PCM, status)         |
+   | $5100       | PRG mode                                      |
+   | $5101       | CHR mode                                      |
+   | $5102-$5103 | PRG RAM protect                               |
+   | $5104       | ExRAM mode                                    |
+   | $5105       | nametable mapping                             |
+   | $5106       | fill mode tile                                |
+   | $5107       | fill mode color                               |
+   | $5113-$5117 | PRG banks                                     |
+   | $5120-$5127 | CHR banks set A (sprites in 8x16 mode)        |
+   | $5128-$512B | CHR banks set B (background in 8x16 mode)     |
+   | $5130       | upper CHR bank bits                           |
+   | $5200-$5202 | vertical split mode, scroll, CHR bank         |
+   | $5203       | IRQ scanline compare                          |
+   | $5204       | IRQ status/enable                             |
+   | $5205-$5206 | 8x8 to 16 multiplier                          |
+   | $5C00-$5FFF | ExRAM                                         |
+   |-------------+-----------------------------------------------|
+*/
+type mmc5 struct {
+	baseMapper
+
+	prg_mode    byte
+	chr_mode    byte
+	ram_protect [2]byte
+	exram_mode  byte
+	nt_mapping  byte
+	fill_tile   byte
+	fill_color  byte
+	prg_banks   [5]byte
+	chr_a       [8]uint16
+	chr_b       [4]uint16
+	chr_upper   byte
+	// last written CHR set is B
+	chr_last_b bool
+	exram      [0x400]byte
+
+	split_control byte
+	split_scroll  byte
+	split_bank    byte
+
+	irq_compare byte
+	irq_enable  bool
+	irq_pending bool
+
+	multiplicand byte
+	multiplier   byte
+
+	// snooped PPU registers
+	sprite_16 bool
+	rendering bool
+
+	// scanline detection
+	in_frame  bool
+	scanline  byte
+	last_addr uint16
+	matches   int
+	fetch     int
+	last_read uint64 // Ppu_m2 of the last PPU read
+	ext_attr  byte
+	split     bool
+	split_y   int
+
+	pulses     [2]mmc5Pulse
+	pcm        byte
+	pcm_read   bool
+	pcm_irq_en bool
+	pcm_irq    bool
+	frame      int
+	apu_cycle  bool
+}
+
+// MMC5 fetch counts per scanline from the scanline detection
+const (
+	MMC5_BG_FETCHES     = 128
+	MMC5_SPRITE_FETCHES = 32
+	// The MMC5 leaves the frame when the PPU stops reading (rendering
+	// disabled or vblank): no PPU read for 3 CPU cycles (M2 rising edges).
+	// During rendering the PPU reads every 2 dots, and the longest gap
+	// (dot 339 to dot 1 of the next line, 3 dots) is one CPU cycle. Time is
+	// measured with Ppu_m2, so it doesn't depend on how far the CPU runs
+	// ahead of the PPU.
+	MMC5_IDLE_CYCLES = 3
+)
+
+func newMmc5() *mmc5 {
+	m := new(mmc5)
+	m.prg_mode = 3
+	m.prg_banks[4] = 0xFF
+	if len(Prg_ram) < 0x10000 {
+		Prg_ram = make([]byte, 0x10000)
+	}
+	return m
+}
+
+/*
+   PRG mode ($5100)
+   |------+-------------+-------------+-------------+-------------|
+   | Mode | $8000-$9FFF | $A000-$BFFF | $C000-$DFFF | $E000-$FFFF |
+   |------+-------------+-------------+-------------+-------------|
+   |    0 | $5117 (32 KB)                                         |
+   |    1 | $5115 (16 KB)             | $5117 (16 KB)             |
+   |    2 | $5115 (16 KB)             | $5116       | $5117       |
+   |    3 | $5114       | $5115       | $5116       | $5117       |
+   |------+-------------+-------------+-------------+-------------|
+   Bit 7 of $5114-$5116 selects ROM (1) or RAM (0)
+*/
+func (m *mmc5) prgBank(addr uint16) (bank int, rom bool) {
+	slot := int(addr-0x8000) / 0x2000
+	// index of the register in prg_banks ($5113-$5117)
+	var reg int
+	var mask byte = 0xFF
+	switch m.prg_mode & 0b11 {
+	case 0:
+		reg, mask = 4, 0x7C
+	case 1:
+		reg, mask = 2+slot/2*2, 0x7E
+		slot %= 2
+	case 2:
+		if slot < 2 {
+			reg, mask = 2, 0x7E
+		} else {
+			reg, slot = 1+slot, 0
+		}
+	default:
+		reg, slot = 1+slot, 0
+	}
+
+	data := m.prg_banks[reg]
+	// $5117 is always ROM
+	rom = reg == 4 || data>>7&0b1 == 1
+	return int(data&mask&0x7F) | slot, rom
+}
+
+func (m *mmc5) ramWritable() bool {
+	return m.ram_protect[0]&0b11 == 0b10 && m.ram_protect[1]&0b11 == 0b01
+}
+
+func (m *mmc5) CpuRead(addr uint16) byte {
+	switch {
+	case addr >= 0x8000:
+		bank, rom := m.prgBank(addr)
+		if !rom {
+			return readBank(Prg_ram, bank, 0x2000, addr)
+		}
+		data := readBank(Prg_rom, bank, 0x2000, addr)
+		if m.pcm_read && addr < 0xC000 {
+			m.writePcm(data)
+		}
+		return data
+	case addr >= 0x6000:
+		return readBank(Prg_ram, int(m.prg_banks[0]&0b111), 0x2000, addr)
+	case addr >= 0x5C00:
+		if m.exram_mode >= 2 {
+			return m.exram[addr-0x5C00]
+		}
+	case addr == 0x5204:
+		var data byte
+		if m.irq_pending {
+			data |= 0x80
+		}
+		if m.in_frame {
+			data |= 0x40
+		}
+		m.irq_pending = false
+		return data
+	case addr == 0x5205:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier))
+	case addr == 0x5206:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier) >> 8)
+	case addr == 0x5010:
+		var data byte
+		if m.pcm_irq {
+			data |= 0x80
+		}
+		if m.pcm_read {
+			data |= 0x01
+		}
+		m.pcm_irq = false
+		return data
+	case addr == 0x5015:
+		var data byte
+		for i := range m.pulses {
+			if m.pulses[i].length > 0 {
+				data |= 1 << i
+			}
+		}
+		return data
+	}
+	return byte(addr >> 8)
+}
+
+func (m *mmc5) CpuWrite(addr uint16, data byte) {
+	switch {
+	case addr == 0x2000:
+		m.sprite_16 = data>>5&0b1 == 1
+	case addr == 0x2001:
+		m.rendering = data&0b00011000 != 0
+		if !m.rendering {
+			m.in_frame = false
+		}
+	case addr >= 0x8000:
+		bank, rom := m.prgBank(addr)
+		if !rom && m.ramWritable() {
+			Prg_ram[(bank*0x2000+int(addr&0x1FFF))%len(Prg_ram)] = data
+		}
+	case addr >= 0x6000:
+		if m.ramWritable() {
+			bank := int(m.prg_banks[0] & 0b111)
+			Prg_ram[(bank*0x2000+int(addr&0x1FFF))%len(Prg_ram)] = data
+		}
+	case addr >= 0x5C00:
+		switch m.exram_mode {
+		case 0, 1:
+			if m.in_frame {
+				m.exram[addr-0x5C00] = data
+			} else {
+				m.exram[addr-0x5C00] = 0
+			}
+		case 2:
+			m.exram[addr-0x5C00] = data
+		}
+	case addr >= 0x5000 && addr <= 0x5007:
+		m.pulses[(addr-0x5000)/4].write(addr&0b11, data)
+	case addr == 0x5010:
+		m.pcm_read = data&0b1 == 1
+		m.pcm_irq_en = data>>7&0b1 == 1
+	case addr == 0x5011:
+		if !m.pcm_read {
+			m.writePcm(data)
+		}
+	case addr == 0x5015:
+		m.pulses[0].setEnable(data&0b01 != 0)
+		m.pulses[1].setEnable(data&0b10 != 0)
+	case addr == 0x5100:
+		m.prg_mode = data & 0b11
+	case addr == 0x5101:
+		m.chr_mode = data & 0b11
+	case addr == 0x5102 || addr == 0x5103:
+		m.ram_protect[addr-0x5102] = data
+	case addr == 0x5104:
+		m.exram_mode = data & 0b11
+	case addr == 0x5105:
+		m.nt_mapping = data
+	case addr == 0x5106:
+		m.fill_tile = data
+	case addr == 0x5107:
+		m.fill_color = data & 0b11
+	case addr >= 0x5113 && addr <= 0x5117:
+		m.prg_banks[addr-0x5113] = data
+	case addr >= 0x5120 && addr <= 0x5127:
+		m.chr_a[addr-0x5120] = uint16(data) | uint16(m.chr_upper)<<8
+		m.chr_last_b = false
+	case addr >= 0x5128 && addr <= 0x512B:
+		m.chr_b[addr-0x5128] = uint16(data) | uint16(m.chr_upper)<<8
+		m.chr_last_b = true
+	case addr == 0x5130:
+		m.chr_upper = data & 0b11
+	case addr == 0x5200:
+		m.split_control = data
+	case addr == 0x5201:
+		m.split_scroll = data
+	case addr == 0x5202:
+		m.split_bank = data
+	case addr == 0x5203:
+		m.irq_compare = data
+	case addr == 0x5204:
+		m.irq_enable = data>>7&0b1 == 1
+	case addr == 0x5205:
+		m.multiplicand = data
+	case addr == 0x5206:
+		m.multiplier = data
+	}
+}
+
+func (m *mmc5) writePcm(data byte) {
+	if data == 0 {
+		m.pcm_irq = m.pcm_irq_en
+		return
+	}
+	m.pcm = data
+}
+
+/*
+   Scanline detection
+   The MMC5 watches the PPU address bus: three reads of the same nametable
+   address are the two dummy fetches at the end of a line and the first
+   fetch of the next line (dots 337, 339 and 1). From there the fetches of
+   a line are counted, fetch 0 is the nametable byte of tile 2 at dot 1.
+   |-----------+---------------------------------------|
+   | Fetch     | Notes                                 |
+   |-----------+---------------------------------------|
+   | 0-127     | background tiles 2-33                 |
+   | 128-159   | sprite patterns                       |
+   | 160-167   | background tiles 0-1 of the next line |
+   | 168-169   | dummy nametable fetches (337, 339)    |
+   |-----------+---------------------------------------|
+*/
+func (m *mmc5) PpuAddr(addr uint16) {
+	m.checkIdle()
+	m.last_read = Ppu_m2
+	if addr >= 0x2000 && addr < 0x3000 && addr == m.last_addr {
+		m.matches++
+	} else {
+		m.matches = 0
+	}
+	m.last_addr = addr
+
+	if m.matches == 2 {
+		m.detectScanline()
+		m.fetch = 0
+	} else {
+		m.fetch++
+	}
+
+	// Nametable fetch of a background tile
+	if m.fetch%4 == 0 && m.bgFetch() {
+		m.ext_attr = m.exram[addr&0x03FF]
+		m.split = m.inSplit()
+	}
+}
+
+func (m *mmc5) detectScanline() {
+	if !m.in_frame {
+		m.in_frame = true
+		m.scanline = 0
+		m.irq_pending = false
+		return
+	}
+	m.scanline++
+	if m.scanline == m.irq_compare {
+		m.irq_pending = true
+	}
+}
+
+func (m *mmc5) bgFetch() bool {
+	return m.in_frame && (m.fetch < MMC5_BG_FETCHES || m.fetch >= MMC5_BG_FETCHES+MMC5_SPRITE_FETCHES)
+}
+
+func (m *mmc5) spriteFetch() bool {
+	return m.in_frame && m.fetch >= MMC5_BG_FETCHES && m.fetch < MMC5_BG_FETCHES+MMC5_SPRITE_FETCHES
+}
+
+/*
+   Vertical split ($5200)
+   |-----+----------------------------------|
+   | bit | Notes                            |
+   |-----+----------------------------------|
+   |   7 | enable                           |
+   |   6 | 0: left side, 1: right side      |
+   | 0-4 | tile where the split starts/ends |
+   |-----+----------------------------------|
+*/
+func (m *mmc5) inSplit() bool {
+	if m.split_control>>7&0b1 == 0 || m.exram_mode >= 2 {
+		return false
+	}
+	tile := m.fetch/4 + 2
+	line := int(m.scanline)
+	if m.fetch >= MMC5_BG_FETCHES {
+		tile = (m.fetch - MMC5_BG_FETCHES - MMC5_SPRITE_FETCHES) / 4
+		line++
+	}
+	m.split_y = (line + int(m.split_scroll)) % 240
+	threshold := int(m.split_control & 0x1F)
+	if m.split_control>>6&0b1 == 1 {
+		return tile >= threshold
+	}
+	return tile < threshold
+}
+
+func (m *mmc5) splitTile() int {
+	tile := m.fetch/4 + 2
+	if m.fetch >= MMC5_BG_FETCHES {
+		tile = (m.fetch - MMC5_BG_FETCHES - MMC5_SPRITE_FETCHES) / 4
+	}
+	return tile % 32
+}
+
+/*
+   Nametable mapping ($5105, 2 bits per nametable)
+   |-------+-----------------------|
+   | Value | Source                |
+   |-------+-----------------------|
+   |     0 | CIRAM page 0          |
+   |     1 | CIRAM page 1          |
+   |     2 | ExRAM (mode 0/1 only) |
+   |     3 | fill mode             |
+   |-------+-----------------------|
+*/
+func (m *mmc5) NtRead(addr uint16) (byte, bool) {
+	offset := addr & 0x03FF
+	attribute := offset >= 0x03C0
+
+	if m.split && m.bgFetch() {
+		row := m.split_y / 8
+		if !attribute {
+			return m.exram[row*32+m.splitTile()], true
+		}
+		at := m.exram[0x3C0+row/4*8+m.splitTile()/4]
+		shift := (row/2%2)*4 + (m.splitTile()/2%2)*2
+		return (at >> shift & 0b11) * 0x55, true
+	}
+	if attribute && m.exram_mode == 1 && m.bgFetch() {
+		return (m.ext_attr >> 6) * 0x55, true
+	}
+
+	switch m.nt_mapping >> ((addr >> 10 & 0b11) * 2) & 0b11 {
+	case 2:
+		if m.exram_mode >= 2 {
+			return 0, true
+		}
+		return m.exram[offset], true
+	case 3:
+		if attribute {
+			return m.fill_color * 0x55, true
+		}
+		return m.fill_tile, true
+	}
+	return 0, false
+}
+
+// CIRAM pages, nametables mapped to ExRAM or fill mode are handled by NtRead
+func (m *mmc5) Mirroring() [4]byte {
+	var pages [4]byte
+	for i := range pages {
+		pages[i] = m.nt_mapping >> (i * 2) & 0b1
+	}
+	return pages
+}
+
+func (m *mmc5) NtWrite(addr uint16, data byte) bool {
+	switch m.nt_mapping >> ((addr >> 10 & 0b11) * 2) & 0b11 {
+	case 2:
+		if m.exram_mode < 2 {
+			m.exram[addr&0x03FF] = data
+		}
+		return true
+	case 3:
+		return true
+	}
+	return false
+}
+
+/*
+   CHR mode ($5101)
+   |------+--------------+-------------------------------|
+   | Mode | Bank size    | Set A registers / set B       |
+   |------+--------------+-------------------------------|
+   |    0 | 8 KB         | $5127 / $512B                 |
+   |    1 | 4 KB         | $5123 $5127 / $512B           |
+   |    2 | 2 KB         | $5121-$5127 odd / $5129 $512B |
+   |    3 | 1 KB         | $5120-$5127 / $5128-$512B     |
+   |------+--------------+-------------------------------|
+*/
+func (m *mmc5) chrBank(addr uint16) (bank int, size int, offset uint16) {
+	if m.bgFetch() {
+		if m.split {
+			return int(m.split_bank), 0x1000, addr&0x0FF8 | uint16(m.split_y&0b111)
+		}
+		if m.exram_mode == 1 {
+			return int(m.ext_attr&0x3F) | int(m.chr_upper)<<6, 0x1000, addr
+		}
+	}
+
+	use_b := m.chr_last_b
+	if m.sprite_16 {
+		use_b = !m.spriteFetch() && m.in_frame
+	}
+
+	bank_size := uint16(0x2000) >> (m.chr_mode & 0b11)
+	if use_b {
+		// set B is used for both pattern tables
+		addr &= 0x0FFF
+		size_b := bank_size
+		if size_b > 0x1000 {
+			size_b = 0x1000
+		}
+		return int(m.chr_b[(addr/size_b+1)*(size_b/0x0400)-1]), int(bank_size), addr
+	}
+	return int(m.chr_a[(addr/bank_size+1)*(bank_size/0x0400)-1]), int(bank_size), addr
+}
+
+func (m *mmc5) PpuRead(addr uint16) byte {
+	bank, size, offset := m.chrBank(addr)
+	return readChr(bank, size, offset)
+}
+
+func (m *mmc5) PpuWrite(addr uint16, data byte) {
+	bank, size, offset := m.chrBank(addr)
+	writeChr(bank, size, offset, data)
+}
+
+// Leave the frame when the PPU stopped reading
+func (m *mmc5) checkIdle() {
+	if m.in_frame && Ppu_m2-m.last_read >= MMC5_IDLE_CYCLES {
+		m.in_frame = false
+		m.last_addr = 0
+	}
+}
+
+func (m *mmc5) Clock() {
+	m.checkIdle()
+
+	m.apu_cycle = !m.apu_cycle
+	if m.apu_cycle {
+		m.pulses[0].clock()
+		m.pulses[1].clock()
+	}
+	m.frame++
+	// Length counter and envelope are clocked at 240 Hz
+	if m.frame >= Console.FrameCycles {
+		m.frame = 0
+		m.pulses[0].clockFrame()
+		m.pulses[1].clockFrame()
+	}
+}
+
+func (m *mmc5) Irq() bool {
+	return (m.irq_pending && m.irq_enable) || m.pcm_irq
+}
+
+// Mix pulses (0-15) x2 and PCM (0-255)
+func (m *mmc5) Output() float32 {
+	pulse := float32(m.pulses[0].output()+m.pulses[1].output()) / 30
+	return (pulse + float32(m.pcm)/255) / 2
+}
+
+var lengthTable = [32]byte{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+var dutyTable = [4][8]byte{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+/*
+   MMC5 pulse (APU pulse without sweep)
+   |----------+-----------+-------------------------------------|
+   | Register | Bits      | Notes                               |
+   |----------+-----------+-------------------------------------|
+   | $5000    | DDLC VVVV | duty, length halt, constant, volume |
+   | $5002    | TTTT TTTT | timer low                           |
+   | $5003    | LLLL LTTT | length counter load, timer high     |
+   |----------+-----------+-------------------------------------|
+*/
+type mmc5Pulse struct {
+	control byte
+	period  uint16
+	timer   uint16
+	step    byte
+	length  byte
+	enable  bool
+
+	env_start   bool
+	env_divider byte
+	env_decay   byte
+}
+
+func (p *mmc5Pulse) write(reg uint16, data byte) {
+	switch reg {
+	case 0:
+		p.control = data
+	case 2:
+		p.period = p.period&0x0700 | uint16(data)
+	case 3:
+		p.period = p.period&0x00FF | uint16(data&0b111)<<8
+		if p.enable {
+			p.length = lengthTable[data>>3]
+		}
+		p.step = 0
+		p.env_start = true
+	}
+}
+
+func (p *mmc5Pulse) setEnable(enable bool) {
+	p.enable = enable
+	if !enable {
+		p.length = 0
+	}
+}
+
+func (p *mmc5Pulse) clock() {
+	if p.timer == 0 {
+		p.timer = p.period
+		p.step = (p.step + 1) & 0b111
+	} else {
+		p.timer--
+	}
+}
+
+// Envelope and length counter
+func (p *mmc5Pulse) clockFrame() {
+	volume := p.control & 0x0F
+	loop := p.control>>5&0b1 == 1
+	if p.env_start {
+		p.env_start = false
+		p.env_decay = 15
+		p.env_divider = volume
+	} else if p.env_divider == 0 {
+		p.env_divider = volume
+		if p.env_decay > 0 {
+			p.env_decay--
+		} else if loop {
+			p.env_decay = 15
+		}
+	} else {
+		p.env_divider--
+	}
+
+	if p.length > 0 && !loop {
+		p.length--
+	}
+}
+
+func (p *mmc5Pulse) output() int {
+	if p.length == 0 || dutyTable[p.control>>6][p.step] == 0 {
+		return 0
+	}
+	if p.control>>4&0b1 == 1 {
+		return int(p.control & 0x0F)
+	}
+	return int(p.env_decay)
+}
@@ -0,0 +1,221 @@
+package casette
+
+import "testing"
+
+func newTestMmc5() *mmc5 {
+	Prg_rom = make([]byte, 0x8000)
+	Chr_rom = filledBanks(128, 0x1000)
+	Chr_ram = nil
+	Ppu_m2 = 0
+	return newMmc5()
+}
+
+// PPU read as the PPU does it, nametable bytes in CIRAM read as 0
+func mmc5Read(m *mmc5, addr uint16) byte {
+	m.PpuAddr(addr)
+	if addr < 0x2000 {
+		return m.PpuRead(addr)
+	}
+	data, _ := m.NtRead(addr)
+	return data
+}
+
+type mmc5Fetch struct {
+	nt, at, lo byte
+}
+
+// Background fetches of the tile of column (0-33) at pixel row y
+func mmc5Tile(m *mmc5, y int, column int) mmc5Fetch {
+	nt := uint16(0x2000 | column/32<<10 | y/8*32 | column%32)
+	at := uint16(0x23C0 | column/32<<10 | y/32*8 | column%32/4)
+	f := mmc5Fetch{nt: mmc5Read(m, nt), at: mmc5Read(m, at)}
+	f.lo = mmc5Read(m, uint16(f.nt)*16+uint16(y%8))
+	mmc5Read(m, uint16(f.nt)*16+uint16(y%8)+8)
+	return f
+}
+
+/*
+   Fetches of a rendering line in the order of the PPU: tiles 2-33 of
+   row y, the sprites, tiles 0-1 of row next and the nametable byte of
+   tile 2 twice. Tiles 0-1 of row y are fetched by the previous line.
+*/
+func mmc5Line(m *mmc5, y int, next int) (tiles []mmc5Fetch) {
+	for column := 2; column < 34; column++ {
+		tiles = append(tiles, mmc5Tile(m, y, column))
+	}
+	for i := 0; i < 8; i++ {
+		mmc5Read(m, 0x2000)
+		mmc5Read(m, 0x2000)
+		mmc5Read(m, 0x0FF0)
+		mmc5Read(m, 0x0FF8)
+	}
+	tiles = append(tiles, mmc5Tile(m, next, 0), mmc5Tile(m, next, 1))
+	mmc5Read(m, uint16(0x2000|next/8*32|2))
+	mmc5Read(m, uint16(0x2000|next/8*32|2))
+	return tiles
+}
+
+func TestMmc5ScanlineIrq(t *testing.T) {
+	m := newTestMmc5()
+	m.CpuWrite(0x2001, 0x18)
+	m.CpuWrite(0x5203, 3)
+	m.CpuWrite(0x5204, 0x80)
+
+	// The pre-render line ends with the fetches that start the frame
+	mmc5Line(m, 0, 0)
+	if m.in_frame {
+		t.Fatal("in frame before line 0")
+	}
+	for y := 0; y < 3; y++ {
+		mmc5Line(m, y, y+1)
+		if !m.in_frame || int(m.scanline) != y || m.Irq() {
+			t.Fatalf("line %d: in frame %t scanline %d IRQ %t", y, m.in_frame, m.scanline, m.Irq())
+		}
+	}
+	mmc5Line(m, 3, 4)
+	if !m.Irq() {
+		t.Errorf("no IRQ at line %d", m.irq_compare)
+	}
+
+	// $5204 acknowledges the IRQ, bit 6 is in frame
+	if got := m.CpuRead(0x5204); got != 0xC0 {
+		t.Errorf("$5204 $%02X, want $C0", got)
+	}
+	if m.Irq() || m.CpuRead(0x5204) != 0x40 {
+		t.Errorf("IRQ not acknowledged by $5204")
+	}
+
+	// The frame ends when the PPU stops reading for 3 CPU cycles
+	Ppu_m2 += MMC5_IDLE_CYCLES - 1
+	m.Clock()
+	if !m.in_frame {
+		t.Errorf("left the frame after %d cycles", MMC5_IDLE_CYCLES-1)
+	}
+	Ppu_m2++
+	m.Clock()
+	if m.in_frame || m.CpuRead(0x5204) != 0x00 {
+		t.Errorf("in frame after %d idle cycles", MMC5_IDLE_CYCLES)
+	}
+
+	// Disabled IRQ is pending without asserting the line
+	m.CpuWrite(0x5204, 0x00)
+	for y := 0; y < 5; y++ {
+		mmc5Line(m, y, y+1)
+	}
+	if !m.irq_pending || m.Irq() {
+		t.Errorf("pending %t IRQ %t, want pending without IRQ", m.irq_pending, m.Irq())
+	}
+}
+
+/*
+   ExRAM mode 1: the byte of ExRAM at the nametable position of a tile
+   selects its palette (bits 6-7) and 4 KB CHR bank (bits 0-5)
+*/
+func TestMmc5ExtendedAttributes(t *testing.T) {
+	m := newTestMmc5()
+	m.CpuWrite(0x2001, 0x18)
+	m.CpuWrite(0x5104, 0x01)
+	m.exram[5] = 0xC7
+	m.exram[6] = 0x42
+	m.exram[32+7] = 0x81
+	m.exram[1] = 0x03
+
+	mmc5Line(m, 0, 0)
+	line0 := mmc5Line(m, 0, 8)
+	want := map[int]mmc5Fetch{
+		5: {0, 0xFF, 7},
+		6: {0, 0x55, 2},
+		7: {0, 0x00, 0},
+	}
+	for column, f := range want {
+		if got := line0[column-2]; got != f {
+			t.Errorf("row 0 column %d: got %+v, want %+v", column, got, f)
+		}
+	}
+	// Tiles 0-1 of the next row are fetched at the end of the line
+	if got := line0[33]; got != (mmc5Fetch{0, 0x00, 0}) {
+		t.Errorf("row 1 column 1: got %+v", got)
+	}
+
+	line8 := mmc5Line(m, 8, 9)
+	if got := line8[5]; got != (mmc5Fetch{0, 0xAA, 1}) {
+		t.Errorf("row 1 column 7: got %+v, want palette 2 bank 1", got)
+	}
+
+	// Upper CHR bits from $5130
+	m.CpuWrite(0x5130, 0x01)
+	if got := mmc5Line(m, 9, 10)[5]; got.lo != 65 {
+		t.Errorf("row 1 column 7 with $5130 1: bank %d, want 65", got.lo)
+	}
+}
+
+/*
+   Vertical split: tiles left of $5200 bits 0-4 come from the ExRAM
+   nametable and attributes with the CHR bank of $5202, scrolled by $5201
+*/
+func TestMmc5VerticalSplit(t *testing.T) {
+	m := newTestMmc5()
+	m.CpuWrite(0x2001, 0x18)
+	m.CpuWrite(0x5200, 0x80|4)
+	m.CpuWrite(0x5201, 0)
+	m.CpuWrite(0x5202, 9)
+	m.exram[2] = 0x11
+	m.exram[3] = 0x12
+	m.exram[32+2] = 0x21
+	// attributes of split tiles 0-3 in row 0-1
+	m.exram[0x3C0] = 0b1110
+
+	mmc5Line(m, 0, 0)
+	line0 := mmc5Line(m, 0, 1)
+	want := []mmc5Fetch{
+		{0x11, 0xFF, 9},
+		{0x12, 0xFF, 9},
+		{0x00, 0x00, 0},
+		{0x00, 0x00, 0},
+	}
+	for i, f := range want {
+		if got := line0[i]; got != f {
+			t.Errorf("tile %d: got %+v, want %+v", i+2, got, f)
+		}
+	}
+	// Tiles 0-1 of the next line are in the split too
+	if got := line0[32]; got.at != 0xAA || got.lo != 9 {
+		t.Errorf("tile 0 of line 1: got %+v, want palette 2 bank 9", got)
+	}
+
+	// The split scrolls by $5201 from the top of the frame
+	m = newTestMmc5()
+	m.CpuWrite(0x2001, 0x18)
+	m.CpuWrite(0x5200, 0xC0|30)
+	m.CpuWrite(0x5201, 8)
+	m.CpuWrite(0x5202, 9)
+	m.exram[32+31] = 0x31
+	mmc5Line(m, 0, 0)
+	line0 = mmc5Line(m, 0, 1)
+	if got := line0[29]; got.nt != 0x31 || got.lo != 9 {
+		t.Errorf("right split tile 31: got %+v, want tile $31 of row 1", got)
+	}
+	if got := line0[27]; got.nt != 0 || got.lo != 0 {
+		t.Errorf("tile 29 outside the split: got %+v", got)
+	}
+}
+
+func TestMmc5Multiplier(t *testing.T) {
+	m := newTestMmc5()
+	tests := []struct {
+		a, b   byte
+		lo, hi byte
+	}{
+		{0, 0, 0x00, 0x00},
+		{200, 100, 0x20, 0x4E},
+		{0xFF, 0xFF, 0x01, 0xFE},
+		{3, 1, 0x03, 0x00},
+	}
+	for _, tt := range tests {
+		m.CpuWrite(0x5205, tt.a)
+		m.CpuWrite(0x5206, tt.b)
+		if lo, hi := m.CpuRead(0x5205), m.CpuRead(0x5206); lo != tt.lo || hi != tt.hi {
+			t.Errorf("%d*%d: got $%02X%02X, want $%02X%02X", tt.a, tt.b, hi, lo, tt.hi, tt.lo)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package casette
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// NSF music file information, nil unless an NSF is loaded
+type NsfInfo struct {
+	Songs int
+	// first song to play, from 0
+	Start int
+
+	Load uint16
+	Init uint16
+	Play uint16
+
+	Name      string
+	Artist    string
+	Copyright string
+	Labels    []string
+
+	// play routine period in microseconds
+	NtscSpeed int
+	PalSpeed  int
+	Pal       bool
+
+	Chips        byte
+	Banks        [8]byte
+	Bankswitched bool
+}
+
+var Nsf *NsfInfo
+
+/*
+   Expansion sound chips
+   |-----+------------+---------------------------------------|
+   | Bit | Chip       | Notes                                 |
+   |-----+------------+---------------------------------------|
+   |   0 | VRC6       | $9000-$9003, $A000-$A002, $B000-$B002 |
+   |   1 | VRC7       | $9010, $9030                          |
+   |   2 | FDS        | $6000-$FFFF is RAM                    |
+   |   3 | MMC5       | $5000-$5015, $5205-$5206, ExRAM       |
+   |   4 | Namco 163  | not supported                         |
+   |   5 | Sunsoft 5B | not supported                         |
+   |-----+------------+---------------------------------------|
+*/
+const (
+	NSF_VRC6 byte = 1 << iota
+	NSF_VRC7
+	NSF_FDS
+	NSF_MMC5
+	NSF_N163
+	NSF_5B
+)
+
+// Internal mapper number of the NSF player
+const NSF_MAPPER = -1
+
+// The CPU waits for the next play call in a JMP loop at NSF_DRIVER_ADDR,
+// which is also the reset vector
+const NSF_DRIVER_ADDR uint16 = 0x4100
+
+/*
+   NSF header (128 bytes)
+   |-----------+--------------------------------------------------------|
+   | Offset    | Notes                                                  |
+   |-----------+--------------------------------------------------------|
+   | $00-$04   | "NESM" followed by $1A                                 |
+   | $05       | version                                                |
+   | $06       | total songs                                            |
+   | $07       | starting song, from 1                                  |
+   | $08-$09   | load address                                           |
+   | $0A-$0B   | init address                                           |
+   | $0C-$0D   | play address                                           |
+   | $0E-$2D   | song name, null terminated                             |
+   | $2E-$4D   | artist, null terminated                                |
+   | $4E-$6D   | copyright, null terminated                             |
+   | $6E-$6F   | NTSC play speed in 1/1000000 seconds                   |
+   | $70-$77   | initial banks of $8000-$FFFF, all 0 if not switched    |
+   | $78-$79   | PAL play speed in 1/1000000 seconds                    |
+   | $7A       | bit 0: PAL, bit 1: dual PAL/NTSC                       |
+   | $7B       | expansion sound chips                                  |
+   | $7C       | NSF2 flags                                             |
+   | $7D-$7F   | NSF2 program data length, 0 for the rest of the file   |
+   |-----------+--------------------------------------------------------|
+*/
+func loadNsf(rom []byte) error {
+	HEADER_SIZE := 0x80
+
+	if len(rom) < HEADER_SIZE {
+		return fmt.Errorf("NSF is truncated")
+	}
+	nsf := new(NsfInfo)
+	nsf.Songs = int(rom[0x06])
+	nsf.Start = int(rom[0x07]) - 1
+	nsf.Load = binary.LittleEndian.Uint16(rom[0x08:])
+	nsf.Init = binary.LittleEndian.Uint16(rom[0x0A:])
+	nsf.Play = binary.LittleEndian.Uint16(rom[0x0C:])
+	nsf.Name = nsfString(rom[0x0E:0x2E])
+	nsf.Artist = nsfString(rom[0x2E:0x4E])
+	nsf.Copyright = nsfString(rom[0x4E:0x6E])
+	nsf.NtscSpeed = int(binary.LittleEndian.Uint16(rom[0x6E:]))
+	copy(nsf.Banks[:], rom[0x70:0x78])
+	nsf.PalSpeed = int(binary.LittleEndian.Uint16(rom[0x78:]))
+	nsf.Pal = rom[0x7A]&0b11 == 0b01
+	nsf.Chips = rom[0x7B]
+
+	data := rom[HEADER_SIZE:]
+	length := int(rom[0x7D]) | int(rom[0x7E])<<8 | int(rom[0x7F])<<16
+	if rom[0x05] >= 2 && length != 0 && length < len(data) {
+		data = data[:length]
+	}
+	return setNsf(nsf, data)
+}
+
+/*
+   NSFe
+   "NSFE" followed by chunks
+   0-3:   Length of the data (little endian)
+   4-7:   Chunk ID
+   8-:    Data
+   Chunks with an upper case first letter are required to play the file
+
+   |------+-------------------------------------------------------------|
+   | ID   | Notes                                                       |
+   |------+-------------------------------------------------------------|
+   | INFO | load, init, play, PAL/NTSC, chips, songs, first song from 0 |
+   | DATA | program data                                                |
+   | BANK | initial banks as NSF $70-$77                                |
+   | RATE | NTSC play speed, PAL play speed                             |
+   | auth | song name, artist, copyright, ripper, null terminated       |
+   | tlbl | track labels, null terminated                               |
+   | NEND | end of file                                                 |
+   |------+-------------------------------------------------------------|
+*/
+func loadNsfe(rom []byte) error {
+	nsf := new(NsfInfo)
+	nsf.Songs = 1
+
+	var data []byte
+	has_info := false
+	for pos := 4; pos < len(rom); {
+		if pos+8 > len(rom) {
+			return fmt.Errorf("chunk header is truncated")
+		}
+		size := int(binary.LittleEndian.Uint32(rom[pos:]))
+		id := string(rom[pos+4 : pos+8])
+		pos += 8
+		if size > len(rom)-pos {
+			return fmt.Errorf("chunk %s is truncated", id)
+		}
+		chunk := rom[pos : pos+size]
+		pos += size
+
+		switch id {
+		case "INFO":
+			if size < 10 {
+				// songs and first song are optional
+				chunk = append(append([]byte(nil), chunk...), make([]byte, 10-size)...)
+				if size < 9 {
+					chunk[8] = 1
+				}
+			}
+			if size < 8 {
+				return fmt.Errorf("INFO chunk is truncated")
+			}
+			nsf.Load = binary.LittleEndian.Uint16(chunk[0:])
+			nsf.Init = binary.LittleEndian.Uint16(chunk[2:])
+			nsf.Play = binary.LittleEndian.Uint16(chunk[4:])
+			nsf.Pal = chunk[6]&0b11 == 0b01
+			nsf.Chips = chunk[7]
+			nsf.Songs = int(chunk[8])
+			nsf.Start = int(chunk[9])
+			has_info = true
+		case "DATA":
+			data = chunk
+		case "BANK":
+			copy(nsf.Banks[:], chunk)
+		case "RATE":
+			if size >= 2 {
+				nsf.NtscSpeed = int(binary.LittleEndian.Uint16(chunk))
+			}
+			if size >= 4 {
+				nsf.PalSpeed = int(binary.LittleEndian.Uint16(chunk[2:]))
+			}
+		case "auth":
+			fields := strings.Split(string(chunk), "\x00")
+			for i, f := range []*string{&nsf.Name, &nsf.Artist, &nsf.Copyright} {
+				if i < len(fields) {
+					*f = fields[i]
+				}
+			}
+		case "tlbl":
+			nsf.Labels = strings.Split(strings.TrimSuffix(string(chunk), "\x00"), "\x00")
+		case "NEND":
+			pos = len(rom)
+		default:
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return fmt.Errorf("required chunk %s is not supported", id)
+			}
+		}
+	}
+	if !has_info || data == nil {
+		return fmt.Errorf("INFO or DATA chunk is missing")
+	}
+	return setNsf(nsf, data)
+}
+
+func nsfString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+// Program data is padded so that bank 0 starts at the 4 KB page of the
+// load address
+func setNsf(nsf *NsfInfo, data []byte) error {
+	if nsf.Songs == 0 {
+		return fmt.Errorf("NSF has no songs")
+	}
+	if nsf.Start < 0 || nsf.Start >= nsf.Songs {
+		nsf.Start = 0
+	}
+	if nsf.NtscSpeed == 0 {
+		nsf.NtscSpeed = 16639
+	}
+	if nsf.PalSpeed == 0 {
+		nsf.PalSpeed = 19997
+	}
+	for _, bank := range nsf.Banks {
+		if bank != 0 {
+			nsf.Bankswitched = true
+		}
+	}
+	Nsf = nsf
+	Prg_rom = append(make([]byte, nsf.Load&0x0FFF), data...)
+	Chr_rom = nil
+
+	Cart = new(Cartridge)
+	Cart.Mapper = NSF_MAPPER
+	Cart.Mirroring = MIRROR_VERTICAL
+	Cart.PrgRamSize = 0x2000
+	Cart.ChrRamSize = 0x2000
+	Cart.Board = "NSF"
+	Cart.Name = nsf.Name
+	if nsf.Pal {
+		Cart.Region = REGION_PAL
+	}
+	if nsf.Chips&NSF_N163 != 0 {
+		Cart.Warnings = append(Cart.Warnings, "Namco 163 audio is not supported")
+	}
+	if nsf.Chips&NSF_5B != 0 {
+		Cart.Warnings = append(Cart.Warnings, "Sunsoft 5B audio is not supported")
+	}
+	return nil
+}
+
+/*
+   NSF player
+   |---------------+-------------------------------------------------|
+   | Address range | Notes                                           |
+   |---------------+-------------------------------------------------|
+   | $4100-$4102   | driver, JMP $4100                               |
+   | $5205-$5206   | MMC5 multiplier, also without the MMC5 chip     |
+   | $5FF6-$5FF7   | FDS: 4 KB banks of $6000-$7FFF                  |
+   | $5FF8-$5FFF   | 4 KB banks of $8000-$FFFF                       |
+   | $6000-$7FFF   | PRG RAM                                         |
+   | $8000-$FFFF   | program data, RAM with FDS                      |
+   | $FFFC-$FFFD   | reset vector, $4100                             |
+   |---------------+-------------------------------------------------|
+*/
+type nsfPlayer struct {
+	baseMapper
+
+	// 4 KB banks of $6000-$FFFF, negative when not mapped
+	banks [10]int
+	// $6000-$FFFF with FDS
+	ram []byte
+
+	vrc6 *vrc6
+	vrc7 *vrc7
+	mmc5 *mmc5
+
+	// Some NSF use the MMC5 multiplier without setting the MMC5 chip bit
+	multiplicand byte
+	multiplier   byte
+}
+
+func newNsfPlayer() *nsfPlayer {
+	m := new(nsfPlayer)
+	if Nsf.Chips&NSF_VRC6 != 0 {
+		m.vrc6 = newVrc6(24)
+	}
+	if Nsf.Chips&NSF_VRC7 != 0 {
+		m.vrc7 = newVrc7(0)
+	}
+	if Nsf.Chips&NSF_MMC5 != 0 {
+		m.mmc5 = newMmc5()
+	}
+	m.reset()
+	return m
+}
+
+// Restore the initial banks and clear RAM before calling init
+func NsfReset() {
+	if m, ok := Cart_mapper.(*nsfPlayer); ok {
+		m.reset()
+	}
+}
+
+func (m *nsfPlayer) reset() {
+	for i := range Prg_ram {
+		Prg_ram[i] = 0
+	}
+	if Nsf.Chips&NSF_FDS != 0 {
+		m.ram = make([]byte, 0xA000)
+	}
+	if m.mmc5 != nil {
+		// ExRAM is plain RAM
+		m.mmc5.CpuWrite(0x5104, 0x02)
+	}
+
+	if Nsf.Bankswitched {
+		for i, bank := range Nsf.Banks {
+			m.banks[2+i] = int(bank)
+		}
+		// FDS banks of $6000-$7FFF are the banks of $E000-$FFFF
+		m.banks[0], m.banks[1] = int(Nsf.Banks[6]), int(Nsf.Banks[7])
+	} else {
+		first := int(Nsf.Load>>12) - 6
+		for i := range m.banks {
+			m.banks[i] = i - first
+		}
+	}
+	for i := range m.banks {
+		m.loadRam(i)
+	}
+}
+
+// Copy the bank of slot to RAM with FDS
+func (m *nsfPlayer) loadRam(slot int) {
+	if m.ram == nil {
+		return
+	}
+	ram := m.ram[slot*0x1000 : (slot+1)*0x1000]
+	for i := range ram {
+		ram[i] = m.readData(slot, i)
+	}
+}
+
+func (m *nsfPlayer) readData(slot int, offset int) byte {
+	bank := m.banks[slot]
+	if bank < 0 || bank*0x1000+offset >= len(Prg_rom) {
+		return 0
+	}
+	return Prg_rom[bank*0x1000+offset]
+}
+
+func (m *nsfPlayer) CpuRead(addr uint16) byte {
+	switch {
+	case addr == 0xFFFC:
+		return byte(NSF_DRIVER_ADDR & 0xFF)
+	case addr == 0xFFFD:
+		return byte(NSF_DRIVER_ADDR >> 8)
+	case addr >= 0x6000 && m.ram != nil:
+		return m.ram[addr-0x6000]
+	case addr >= 0x8000:
+		slot := int(addr>>12) - 6
+		return m.readData(slot, int(addr&0x0FFF))
+	case addr >= 0x6000:
+		return Prg_ram[addr-0x6000]
+	case addr >= NSF_DRIVER_ADDR && addr <= NSF_DRIVER_ADDR+2:
+		driver := [3]byte{0x4C, byte(NSF_DRIVER_ADDR & 0xFF), byte(NSF_DRIVER_ADDR >> 8)}
+		return driver[addr-NSF_DRIVER_ADDR]
+	case addr == 0x5205:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier))
+	case addr == 0x5206:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier) >> 8)
+	case m.mmc5 != nil && addr >= 0x5C00 && addr <= 0x5FF5:
+		return m.mmc5.CpuRead(addr)
+	}
+	return byte(addr >> 8)
+}
+
+func (m *nsfPlayer) CpuWrite(addr uint16, data byte) {
+	switch {
+	case addr >= 0x6000 && m.ram != nil:
+		m.ram[addr-0x6000] = data
+	case addr >= 0x6000 && addr < 0x8000:
+		Prg_ram[addr-0x6000] = data
+	case addr >= 0x5FF8 && addr <= 0x5FFF:
+		slot := int(addr-0x5FF8) + 2
+		m.banks[slot] = int(data)
+		m.loadRam(slot)
+	case addr >= 0x5FF6 && addr <= 0x5FF7 && m.ram != nil:
+		slot := int(addr - 0x5FF6)
+		m.banks[slot] = int(data)
+		m.loadRam(slot)
+	case addr == 0x5205:
+		m.multiplicand = data
+	case addr == 0x5206:
+		m.multiplier = data
+	}
+
+	switch {
+	case m.vrc6 != nil && addr >= 0x9000 && addr < 0xC000 && addr&0x0FFF <= 0x0003:
+		m.vrc6.CpuWrite(addr, data)
+	case m.vrc7 != nil && (addr == 0x9010 || addr == 0x9030):
+		m.vrc7.CpuWrite(addr, data)
+	case m.mmc5 != nil && (addr >= 0x5000 && addr <= 0x5015 || addr >= 0x5C00 && addr <= 0x5FF5):
+		m.mmc5.CpuWrite(addr, data)
+	}
+}
+
+func (m *nsfPlayer) PpuRead(addr uint16) byte {
+	return Chr_ram[addr&0x1FFF]
+}
+
+func (m *nsfPlayer) PpuWrite(addr uint16, data byte) {
+	Chr_ram[addr&0x1FFF] = data
+}
+
+func (m *nsfPlayer) Clock() {
+	if m.vrc6 != nil {
+		m.vrc6.Clock()
+	}
+	if m.vrc7 != nil {
+		m.vrc7.Clock()
+	}
+	if m.mmc5 != nil {
+		m.mmc5.Clock()
+	}
+}
+
+func (m *nsfPlayer) Output() float32 {
+	var out float32
+	if m.vrc6 != nil {
+		out += m.vrc6.Output()
+	}
+	if m.vrc7 != nil {
+		out += m.vrc7.Output()
+	}
+	if m.mmc5 != nil {
+		out += m.mmc5.Output()
+	}
+	return out
+}
@@ -0,0 +1,230 @@
+package casette
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// NSF image with the header fields of info followed by data
+func nsfImage(info NsfInfo, data []byte) []byte {
+	rom := make([]byte, 0x80)
+	copy(rom, "NESM\x1a")
+	rom[0x05] = 1
+	rom[0x06] = byte(info.Songs)
+	rom[0x07] = byte(info.Start + 1)
+	binary.LittleEndian.PutUint16(rom[0x08:], info.Load)
+	binary.LittleEndian.PutUint16(rom[0x0A:], info.Init)
+	binary.LittleEndian.PutUint16(rom[0x0C:], info.Play)
+	copy(rom[0x0E:0x2D], info.Name)
+	copy(rom[0x2E:0x4D], info.Artist)
+	copy(rom[0x4E:0x6D], info.Copyright)
+	binary.LittleEndian.PutUint16(rom[0x6E:], uint16(info.NtscSpeed))
+	copy(rom[0x70:0x78], info.Banks[:])
+	binary.LittleEndian.PutUint16(rom[0x78:], uint16(info.PalSpeed))
+	if info.Pal {
+		rom[0x7A] = 0b01
+	}
+	rom[0x7B] = info.Chips
+	return append(rom, data...)
+}
+
+// Program data of 4 KB banks filled with the bank number
+func nsfBanks(n int) []byte {
+	var data []byte
+	for i := 0; i < n; i++ {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, 0x1000)...)
+	}
+	return data
+}
+
+func TestLoadNsf(t *testing.T) {
+	info := NsfInfo{
+		Songs: 5, Start: 2,
+		Load: 0x8123, Init: 0x8200, Play: 0x8300,
+		Name: "Song", Artist: "Artist", Copyright: "2000 Someone",
+		NtscSpeed: 16666, PalSpeed: 20000, Pal: true,
+		Chips: NSF_VRC6 | NSF_N163 | NSF_5B,
+	}
+	if err := SetRom(writeRom(t, "music.nsf", nsfImage(info, []byte{1, 2, 3}))); err != nil {
+		t.Fatal(err)
+	}
+
+	want := info
+	if !reflect.DeepEqual(*Nsf, want) {
+		t.Errorf("got %+v, want %+v", *Nsf, want)
+	}
+	if Cart.Mapper != NSF_MAPPER || Cart.Name != "Song" || Cart.Region != REGION_PAL {
+		t.Errorf("cartridge mapper %d name %q region %d", Cart.Mapper, Cart.Name, Cart.Region)
+	}
+	warnings := []string{"Namco 163 audio is not supported", "Sunsoft 5B audio is not supported"}
+	if !reflect.DeepEqual(Cart.Warnings, warnings) {
+		t.Errorf("warnings %q, want %q", Cart.Warnings, warnings)
+	}
+
+	// Data starts at the offset of the load address in its 4 KB page
+	if len(Prg_rom) != 0x126 || !bytes.Equal(Prg_rom[0x123:], []byte{1, 2, 3}) {
+		t.Errorf("PRG ROM is not padded to the load address")
+	}
+	for addr, want := range map[uint16]byte{0x8122: 0, 0x8123: 1, 0x8125: 3, 0xFFFC: 0x00, 0xFFFD: 0x41, 0x4100: 0x4C, 0x4101: 0x00, 0x4102: 0x41} {
+		if got := Cart_mapper.CpuRead(addr); got != want {
+			t.Errorf("$%04X: got $%02X, want $%02X", addr, got, want)
+		}
+	}
+}
+
+func TestLoadNsfDefaults(t *testing.T) {
+	info := NsfInfo{Songs: 3, Start: 7, Load: 0x8000}
+	if err := SetRom(writeRom(t, "music.nsf", nsfImage(info, []byte{0}))); err != nil {
+		t.Fatal(err)
+	}
+	if Nsf.Start != 0 || Nsf.NtscSpeed != 16639 || Nsf.PalSpeed != 19997 || Nsf.Bankswitched {
+		t.Errorf("start %d speeds %d/%d bankswitched %t", Nsf.Start, Nsf.NtscSpeed, Nsf.PalSpeed, Nsf.Bankswitched)
+	}
+	if len(Cart.Warnings) != 0 {
+		t.Errorf("unexpected warnings %q", Cart.Warnings)
+	}
+
+	info.Songs = 0
+	err := SetRom(writeRom(t, "music.nsf", nsfImage(info, []byte{0})))
+	if err == nil || !strings.Contains(err.Error(), "NSF has no songs") {
+		t.Errorf("error %v, want no songs", err)
+	}
+}
+
+func TestNsfBankswitch(t *testing.T) {
+	// Without bankswitching the data is mapped from the load address
+	info := NsfInfo{Songs: 1, Load: 0xA000}
+	if err := SetRom(writeRom(t, "music.nsf", nsfImage(info, nsfBanks(3)))); err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[uint16]byte{0xA000: 0, 0xBFFF: 1, 0xC000: 2, 0x8000: 0, 0xD000: 0} {
+		if got := Cart_mapper.CpuRead(addr); got != want {
+			t.Errorf("linear $%04X: got %d, want %d", addr, got, want)
+		}
+	}
+
+	// Initial banks from the header, $5FF8-$5FFF switch them until reset
+	info = NsfInfo{Songs: 1, Load: 0x8000, Banks: [8]byte{3, 2, 1, 0, 0, 0, 0, 4}}
+	if err := SetRom(writeRom(t, "music.nsf", nsfImage(info, nsfBanks(5)))); err != nil {
+		t.Fatal(err)
+	}
+	if !Nsf.Bankswitched {
+		t.Fatal("bankswitched is false")
+	}
+	banks := func(name string, want [8]byte) {
+		t.Helper()
+		for i, b := range want {
+			if got := Cart_mapper.CpuRead(0x8000 + uint16(i)*0x1000); got != b {
+				t.Errorf("%s: bank of $%04X is %d, want %d", name, 0x8000+i*0x1000, got, b)
+			}
+		}
+	}
+	banks("init", Nsf.Banks)
+	Cart_mapper.CpuWrite(0x5FF8, 4)
+	Cart_mapper.CpuWrite(0x5FFF, 1)
+	banks("switched", [8]byte{4, 2, 1, 0, 0, 0, 0, 1})
+	NsfReset()
+	banks("reset", Nsf.Banks)
+
+	// With FDS $6000-$FFFF is RAM loaded from the banks, $5FF6-$5FF7 switch $6000-$7FFF
+	info.Chips = NSF_FDS
+	if err := SetRom(writeRom(t, "music.nsf", nsfImage(info, nsfBanks(5)))); err != nil {
+		t.Fatal(err)
+	}
+	if got := Cart_mapper.CpuRead(0x6000); got != 0 {
+		t.Errorf("FDS $6000: got %d, want bank 0 of $E000", got)
+	}
+	if got := Cart_mapper.CpuRead(0x7000); got != 4 {
+		t.Errorf("FDS $7000: got %d, want bank 4 of $F000", got)
+	}
+	Cart_mapper.CpuWrite(0x8000, 0xAA)
+	if got := Cart_mapper.CpuRead(0x8000); got != 0xAA {
+		t.Errorf("FDS $8000: got $%02X after write, want $AA", got)
+	}
+	Cart_mapper.CpuWrite(0x5FF6, 2)
+	if got := Cart_mapper.CpuRead(0x6000); got != 2 {
+		t.Errorf("FDS $6000: got %d after $5FF6 write, want 2", got)
+	}
+}
+
+func TestLoadNsfe(t *testing.T) {
+	chunk := func(id string, data []byte) []byte {
+		size := make([]byte, 4)
+		binary.LittleEndian.PutUint32(size, uint32(len(data)))
+		return append(append(size, id...), data...)
+	}
+	info := []byte{0x00, 0x80, 0x00, 0x81, 0x00, 0x82, 0b01, NSF_MMC5, 2, 1}
+	rom := bytes.Join([][]byte{
+		[]byte("NSFE"),
+		chunk("INFO", info),
+		chunk("BANK", []byte{1, 0}),
+		chunk("RATE", []byte{0x10, 0x27, 0x20, 0x4E}),
+		chunk("auth", []byte("Song\x00Artist\x00Copyright\x00Ripper\x00")),
+		chunk("tlbl", []byte("One\x00Two\x00")),
+		chunk("DATA", nsfBanks(2)),
+		chunk("NEND", nil),
+	}, nil)
+	if err := SetRom(writeRom(t, "music.nsfe", rom)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := NsfInfo{
+		Songs: 2, Start: 1,
+		Load: 0x8000, Init: 0x8100, Play: 0x8200,
+		Name: "Song", Artist: "Artist", Copyright: "Copyright",
+		Labels:    []string{"One", "Two"},
+		NtscSpeed: 10000, PalSpeed: 20000, Pal: true,
+		Chips: NSF_MMC5, Banks: [8]byte{1, 0}, Bankswitched: true,
+	}
+	if !reflect.DeepEqual(*Nsf, want) {
+		t.Errorf("got %+v, want %+v", *Nsf, want)
+	}
+	if got := Cart_mapper.CpuRead(0x8000); got != 1 {
+		t.Errorf("$8000: got bank %d, want 1", got)
+	}
+
+	tests := []struct {
+		name string
+		rom  []byte
+		err  string
+	}{
+		{"required chunk", bytes.Join([][]byte{[]byte("NSFE"), chunk("INFO", info), chunk("DATA", []byte{0}), chunk("VRC7", nil)}, nil), "required chunk VRC7 is not supported"},
+		{"no data", bytes.Join([][]byte{[]byte("NSFE"), chunk("INFO", info)}, nil), "INFO or DATA chunk is missing"},
+		{"short info", bytes.Join([][]byte{[]byte("NSFE"), chunk("INFO", info[:6])}, nil), "INFO chunk is truncated"},
+		{"truncated", append([]byte("NSFE"), chunk("DATA", []byte{0, 1})[:9]...), "chunk DATA is truncated"},
+	}
+	for _, tt := range tests {
+		err := SetRom(writeRom(t, "music.nsfe", tt.rom))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// The MMC5 multiplier is there without the MMC5 chip bit, other
+// $4xxx-$5xxx addresses are open bus
+func TestNsfPlayerRegisters(t *testing.T) {
+	for _, chips := range []byte{0, NSF_MMC5} {
+		info := NsfInfo{Songs: 1, Load: 0x8000, Chips: chips}
+		if err := SetRom(writeRom(t, "music.nsf", nsfImage(info, []byte{0}))); err != nil {
+			t.Fatal(err)
+		}
+		Cart_mapper.CpuWrite(0x5205, 0xC8)
+		Cart_mapper.CpuWrite(0x5206, 0x37)
+		if got := uint16(Cart_mapper.CpuRead(0x5206))<<8 | uint16(Cart_mapper.CpuRead(0x5205)); got != 0xC8*0x37 {
+			t.Errorf("chips $%02X: product $%04X, want $%04X", chips, got, 0xC8*0x37)
+		}
+		if got := Cart_mapper.CpuRead(0x5207); got != 0x52 {
+			t.Errorf("chips $%02X: $5207 $%02X, want open bus $52", chips, got)
+		}
+
+		// CHR RAM is 8 KB
+		Cart_mapper.PpuWrite(0x3FF0, 0xAB)
+		if Chr_ram[0x1FF0] != 0xAB || Cart_mapper.PpuRead(0x1FF0) != 0xAB {
+			t.Errorf("chips $%02X: CHR write to $3FF0 not at $1FF0", chips)
+		}
+	}
+}
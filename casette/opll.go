@@ -0,0 +1,271 @@
+package casette
+
+import "math"
+
+/*
+   VRC7 FM synthesis (YM2413 / OPLL derivative, 6 two-operator channels)
+   |-------------+-----------+---------------------------------------------------|
+   | Register    | Bits      | Notes                                             |
+   |-------------+-----------+---------------------------------------------------|
+   | $00/$01     | TVSK MMMM | modulator/carrier: tremolo, vibrato, sustained,   |
+   |             |           | key scale rate, multiplier                        |
+   | $02         | KKOO OOOO | modulator key scale level, output level           |
+   | $03         | KK-Q WFFF | carrier key scale level, carrier/modulator        |
+   |             |           | rectification, modulator feedback                 |
+   | $04/$05     | AAAA DDDD | modulator/carrier attack, decay                   |
+   | $06/$07     | SSSS RRRR | modulator/carrier sustain level, release          |
+   | $10-$15     | LLLL LLLL | channel frequency low                             |
+   | $20-$25     | --ST OOOH | sustain, trigger, octave, frequency high          |
+   | $30-$35     | IIII VVVV | instrument, volume                                |
+   |-------------+-----------+---------------------------------------------------|
+   Samples are produced at CPU clock / 36 (49716 Hz for NTSC).
+*/
+
+// Built-in instruments 1-15, instrument 0 is the custom patch in $00-$07
+var vrc7Patches = [15][8]byte{
+	{0x03, 0x21, 0x05, 0x06, 0xE8, 0x81, 0x42, 0x27},
+	{0x13, 0x41, 0x14, 0x0D, 0xD8, 0xF6, 0x23, 0x12},
+	{0x11, 0x11, 0x08, 0x08, 0xFA, 0xB2, 0x20, 0x12},
+	{0x31, 0x61, 0x0C, 0x07, 0xA8, 0x64, 0x61, 0x27},
+	{0x32, 0x21, 0x1E, 0x06, 0xE1, 0x76, 0x01, 0x28},
+	{0x02, 0x01, 0x06, 0x00, 0xA3, 0xE2, 0xF4, 0xF4},
+	{0x21, 0x61, 0x1D, 0x07, 0x82, 0x81, 0x11, 0x07},
+	{0x23, 0x21, 0x22, 0x17, 0xA2, 0x72, 0x01, 0x17},
+	{0x35, 0x11, 0x25, 0x00, 0x40, 0x73, 0x72, 0x01},
+	{0xB5, 0x01, 0x0F, 0x0F, 0xA8, 0xA5, 0x51, 0x02},
+	{0x17, 0xC1, 0x24, 0x07, 0xF8, 0xF8, 0x22, 0x12},
+	{0x71, 0x23, 0x11, 0x06, 0x65, 0x74, 0x18, 0x16},
+	{0x01, 0x02, 0xD3, 0x05, 0xC9, 0x95, 0x03, 0x02},
+	{0x61, 0x63, 0x0C, 0x00, 0x94, 0xC0, 0x33, 0xF6},
+	{0x21, 0x72, 0x0D, 0x00, 0xC1, 0xD5, 0x56, 0x06},
+}
+
+var opllMultiplier = [16]float64{0.5, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 10, 12, 12, 15, 15}
+
+const (
+	OPLL_CLOCK_DIVIDER = 36
+	OPLL_SAMPLE_RATE   = 49716.0
+	// Attenuation where an operator is considered silent
+	OPLL_MAX_DB = 48.0
+)
+
+/*
+   Envelope state
+   |---------+-------|
+   | State   | Value |
+   |---------+-------|
+   | Attack  |     0 |
+   | Decay   |     1 |
+   | Sustain |     2 |
+   | Release |     3 |
+   | Off     |     4 |
+   |---------+-------|
+*/
+const (
+	EG_ATTACK byte = iota
+	EG_DECAY
+	EG_SUSTAIN
+	EG_RELEASE
+	EG_OFF
+)
+
+type opllOperator struct {
+	phase float64
+	// attenuation in dB
+	env      float64
+	eg_state byte
+	last     [2]float64
+}
+
+type opllChannel struct {
+	fnum    uint16
+	block   byte
+	key     bool
+	sustain bool
+	patch   byte
+	volume  byte
+	ops     [2]opllOperator
+}
+
+type opll struct {
+	regs     [8]byte
+	channels [6]opllChannel
+	divider  int
+	lfo      float64
+	sample   float64
+}
+
+func newOpll() *opll {
+	fm := new(opll)
+	fm.reset()
+	return fm
+}
+
+func (fm *opll) reset() {
+	for i := range fm.channels {
+		fm.channels[i] = opllChannel{}
+		for j := range fm.channels[i].ops {
+			fm.channels[i].ops[j].env = OPLL_MAX_DB
+			fm.channels[i].ops[j].eg_state = EG_OFF
+		}
+	}
+	fm.sample = 0
+}
+
+func (fm *opll) write(reg byte, data byte) {
+	switch {
+	case reg < 0x08:
+		fm.regs[reg] = data
+	case reg >= 0x10 && reg <= 0x15:
+		ch := &fm.channels[reg-0x10]
+		ch.fnum = ch.fnum&0x100 | uint16(data)
+	case reg >= 0x20 && reg <= 0x25:
+		ch := &fm.channels[reg-0x20]
+		ch.fnum = ch.fnum&0x0FF | uint16(data&0b1)<<8
+		ch.block = data >> 1 & 0b111
+		ch.sustain = data>>5&0b1 == 1
+		key := data>>4&0b1 == 1
+		if key && !ch.key {
+			for i := range ch.ops {
+				ch.ops[i].eg_state = EG_ATTACK
+				ch.ops[i].phase = 0
+			}
+		} else if !key && ch.key {
+			for i := range ch.ops {
+				ch.ops[i].eg_state = EG_RELEASE
+			}
+		}
+		ch.key = key
+	case reg >= 0x30 && reg <= 0x35:
+		ch := &fm.channels[reg-0x30]
+		ch.patch = data >> 4
+		ch.volume = data & 0x0F
+	}
+}
+
+func (fm *opll) patch(n byte) [8]byte {
+	if n == 0 {
+		return fm.regs
+	}
+	return vrc7Patches[n-1]
+}
+
+func (fm *opll) clock() {
+	fm.divider++
+	if fm.divider < OPLL_CLOCK_DIVIDER {
+		return
+	}
+	fm.divider = 0
+
+	fm.lfo += 1 / OPLL_SAMPLE_RATE
+	var sum float64
+	for i := range fm.channels {
+		sum += fm.channels[i].sample(fm.patch(fm.channels[i].patch), fm.lfo)
+	}
+	fm.sample = sum / float64(len(fm.channels))
+}
+
+// Output centered at 0.5
+func (fm *opll) output() float32 {
+	return float32(0.5 + fm.sample/2)
+}
+
+func (ch *opllChannel) sample(patch [8]byte, lfo float64) float64 {
+	// modulator
+	mod := &ch.ops[0]
+	mod.envelope(ch, patch, 0)
+	var feedback float64
+	if fb := patch[3] & 0b111; fb != 0 {
+		feedback = (mod.last[0] + mod.last[1]) / 2 * math.Pow(2, float64(fb)-7)
+	}
+	tl := float64(patch[2]&0x3F) * 0.75
+	m := mod.operate(ch, patch[0], tl, patch[3]>>3&0b1 == 1, feedback, lfo)
+	mod.last[1] = mod.last[0]
+	mod.last[0] = m
+
+	// carrier
+	car := &ch.ops[1]
+	car.envelope(ch, patch, 1)
+	volume := float64(ch.volume) * 3
+	return car.operate(ch, patch[1], volume, patch[3]>>4&0b1 == 1, m*2, lfo)
+}
+
+func (op *opllOperator) operate(ch *opllChannel, flags byte, attenuation float64, rectify bool, modulation float64, lfo float64) float64 {
+	step := float64(ch.fnum) * math.Pow(2, float64(ch.block)-1) / (1 << 18)
+	step *= opllMultiplier[flags&0x0F]
+	// vibrato: 6.4 Hz, about +-14 cents
+	if flags>>6&0b1 == 1 {
+		step *= 1 + 0.008*math.Sin(2*math.Pi*6.4*lfo)
+	}
+	op.phase = math.Mod(op.phase+step, 1)
+
+	out := math.Sin(2 * math.Pi * (op.phase + modulation))
+	if rectify && out < 0 {
+		out = 0
+	}
+
+	attenuation += op.env
+	// tremolo: 3.7 Hz, 4.8 dB
+	if flags>>7&0b1 == 1 {
+		attenuation += 2.4 * (1 + math.Sin(2*math.Pi*3.7*lfo))
+	}
+	if attenuation >= OPLL_MAX_DB {
+		return 0
+	}
+	return out * math.Pow(10, -attenuation/20)
+}
+
+// Advance envelope generator of operator n (0: modulator, 1: carrier)
+func (op *opllOperator) envelope(ch *opllChannel, patch [8]byte, n int) {
+	flags := patch[n]
+	ksr := int(ch.block) >> 1
+	if flags>>4&0b1 == 1 {
+		ksr = int(ch.block)<<1 | int(ch.fnum>>8)
+	}
+	rate := func(r byte) float64 {
+		if r == 0 {
+			return 0
+		}
+		return math.Pow(2, float64(r)-1+float64(ksr)/4)
+	}
+
+	attack := patch[4+n] >> 4
+	decay := patch[4+n] & 0x0F
+	sustain_level := float64(patch[6+n]>>4) * 3
+	release := patch[6+n] & 0x0F
+	if ch.sustain && op.eg_state == EG_RELEASE {
+		release = 5
+	}
+
+	switch op.eg_state {
+	case EG_ATTACK:
+		if attack == 15 {
+			op.env = 0
+		} else {
+			op.env -= OPLL_MAX_DB / (2.826 * OPLL_SAMPLE_RATE) * rate(attack)
+		}
+		if op.env <= 0 {
+			op.env = 0
+			op.eg_state = EG_DECAY
+		}
+	case EG_DECAY:
+		op.env += OPLL_MAX_DB / (39.28 * OPLL_SAMPLE_RATE) * rate(decay)
+		if op.env >= sustain_level {
+			op.env = sustain_level
+			op.eg_state = EG_SUSTAIN
+		}
+	case EG_SUSTAIN:
+		// percussive tones keep decaying with the release rate
+		if flags>>5&0b1 == 0 {
+			op.env += OPLL_MAX_DB / (39.28 * OPLL_SAMPLE_RATE) * rate(release)
+		}
+	case EG_RELEASE:
+		op.env += OPLL_MAX_DB / (39.28 * OPLL_SAMPLE_RATE) * rate(release)
+	}
+	if op.env >= OPLL_MAX_DB {
+		op.env = OPLL_MAX_DB
+		if op.eg_state != EG_ATTACK {
+			op.eg_state = EG_OFF
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package casette
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+)
+
+// Patch files applied in order when the ROM is loaded, empty to use the
+// first patch found with the same name as the ROM (.ips, .ups, .bps)
+var Patches []string
+
+// Largest ROM a UPS or BPS patch can produce, the size is read from the
+// patch before the output is allocated
+const PATCH_MAX_SIZE = 16 << 20
+
+// Apply patches to the ROM image in memory, the ROM file is never modified
+func applyPatches(rom_path string, rom []byte) ([]byte, error) {
+	paths := Patches
+	if len(paths) == 0 {
+		name := romBase(rom_path)
+		for _, ext := range []string{".ips", ".ups", ".bps"} {
+			if _, err := os.Stat(name + ext); err == nil {
+				paths = append(paths, name+ext)
+				break
+			}
+		}
+	}
+
+	for _, path := range paths {
+		patch, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rom, err = applyPatch(rom, patch)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return rom, nil
+}
+
+// Apply an IPS, UPS or BPS patch
+func applyPatch(rom []byte, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, []byte("PATCH")):
+		return patchIps(rom, patch)
+	case bytes.HasPrefix(patch, []byte("UPS1")):
+		return patchUps(rom, patch)
+	case bytes.HasPrefix(patch, []byte("BPS1")):
+		return patchBps(rom, patch)
+	default:
+		return nil, fmt.Errorf("unknown patch format")
+	}
+}
+
+/*
+   IPS
+   |----------+-------+---------------------------------------------|
+   | Field    | Bytes | Notes                                       |
+   |----------+-------+---------------------------------------------|
+   | "PATCH"  |     5 |                                             |
+   | offset   |     3 | big endian, "EOF" ends the records          |
+   | size     |     2 | big endian, 0 for a RLE record              |
+   | data     |  size |                                             |
+   | RLE size |     2 | RLE record only                             |
+   | RLE byte |     1 | RLE record only, repeated RLE size times    |
+   | truncate |     3 | optional after "EOF", size of the output    |
+   |----------+-------+---------------------------------------------|
+*/
+func patchIps(rom []byte, patch []byte) ([]byte, error) {
+	out := append([]byte(nil), rom...)
+	pos := 5
+
+	for {
+		if pos+3 > len(patch) {
+			return nil, fmt.Errorf("unexpected end of patch")
+		}
+		if string(patch[pos:pos+3]) == "EOF" {
+			pos += 3
+			break
+		}
+		if pos+5 > len(patch) {
+			return nil, fmt.Errorf("unexpected end of patch")
+		}
+		offset := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		size := int(binary.BigEndian.Uint16(patch[pos+3:]))
+		pos += 5
+
+		var data []byte
+		if size == 0 {
+			if pos+3 > len(patch) {
+				return nil, fmt.Errorf("unexpected end of patch")
+			}
+			size = int(binary.BigEndian.Uint16(patch[pos:]))
+			data = bytes.Repeat(patch[pos+2:pos+3], size)
+			pos += 3
+		} else {
+			if pos+size > len(patch) {
+				return nil, fmt.Errorf("unexpected end of patch")
+			}
+			data = patch[pos : pos+size]
+			pos += size
+		}
+
+		if offset+size > len(out) {
+			out = append(out, make([]byte, offset+size-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+
+	if pos+3 <= len(patch) {
+		size := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		if size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// Variable length number of UPS and BPS
+func readVarint(patch []byte, pos *int) (int, error) {
+	data, shift := 0, 1
+	for {
+		if *pos >= len(patch) {
+			return 0, fmt.Errorf("unexpected end of patch")
+		}
+		x := int(patch[*pos])
+		*pos++
+		data += (x & 0x7F) * shift
+		if x&0x80 != 0 {
+			return data, nil
+		}
+		shift <<= 7
+		data += shift
+	}
+}
+
+/*
+   UPS and BPS end with a 12 bytes footer
+   |--------------+-------+------------------------------------------|
+   | Field        | Bytes | Notes                                    |
+   |--------------+-------+------------------------------------------|
+   | source CRC32 |     4 | little endian                            |
+   | target CRC32 |     4 | little endian                            |
+   | patch CRC32  |     4 | little endian, of the patch before this  |
+   |--------------+-------+------------------------------------------|
+*/
+func checkFooter(patch []byte) (source uint32, target uint32, err error) {
+	if len(patch) < 12 {
+		return 0, 0, fmt.Errorf("unexpected end of patch")
+	}
+	footer := patch[len(patch)-12:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return 0, 0, fmt.Errorf("patch checksum mismatch")
+	}
+	return binary.LittleEndian.Uint32(footer[0:]), binary.LittleEndian.Uint32(footer[4:]), nil
+}
+
+/*
+   UPS
+   "UPS1", source size, target size, hunks, footer
+   Hunk: relative offset, bytes XORed with the source until a zero byte
+*/
+func patchUps(rom []byte, patch []byte) ([]byte, error) {
+	source_crc, target_crc, err := checkFooter(patch)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(rom) != source_crc {
+		return nil, fmt.Errorf("source checksum mismatch")
+	}
+
+	pos := 4
+	end := len(patch) - 12
+	source_size, err := readVarint(patch, &pos)
+	if err != nil {
+		return nil, err
+	}
+	target_size, err := readVarint(patch, &pos)
+	if err != nil {
+		return nil, err
+	}
+	if source_size != len(rom) {
+		return nil, fmt.Errorf("source size mismatch")
+	}
+	if target_size > PATCH_MAX_SIZE {
+		return nil, fmt.Errorf("target size %d is too large", target_size)
+	}
+
+	out := make([]byte, target_size)
+	copy(out, rom)
+
+	addr := 0
+	for pos < end {
+		offset, err := readVarint(patch, &pos)
+		if err != nil {
+			return nil, err
+		}
+		addr += offset
+		for {
+			if pos >= end {
+				return nil, fmt.Errorf("unexpected end of patch")
+			}
+			x := patch[pos]
+			pos++
+			if x == 0 {
+				addr++
+				break
+			}
+			if addr < len(out) {
+				out[addr] ^= x
+			}
+			addr++
+		}
+	}
+
+	if crc32.ChecksumIEEE(out) != target_crc {
+		return nil, fmt.Errorf("target checksum mismatch")
+	}
+	return out, nil
+}
+
+/*
+   BPS
+   "BPS1", source size, target size, metadata size, metadata, actions, footer
+   |--------+-------------+-------------------------------------------------|
+   | Action | Name        | Notes                                           |
+   |--------+-------------+-------------------------------------------------|
+   |      0 | SourceRead  | copy from the source at the output offset       |
+   |      1 | TargetRead  | copy from the patch                             |
+   |      2 | SourceCopy  | copy from the source at a relative offset       |
+   |      3 | TargetCopy  | copy from the output at a relative offset       |
+   |--------+-------------+-------------------------------------------------|
+   Action number is the low 2 bits of a number, the length - 1 the rest
+*/
+func patchBps(rom []byte, patch []byte) ([]byte, error) {
+	source_crc, target_crc, err := checkFooter(patch)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(rom) != source_crc {
+		return nil, fmt.Errorf("source checksum mismatch")
+	}
+
+	pos := 4
+	end := len(patch) - 12
+	var sizes [3]int
+	for i := range sizes {
+		if sizes[i], err = readVarint(patch, &pos); err != nil {
+			return nil, err
+		}
+	}
+	source_size, target_size, metadata_size := sizes[0], sizes[1], sizes[2]
+	if source_size != len(rom) {
+		return nil, fmt.Errorf("source size mismatch")
+	}
+	if target_size > PATCH_MAX_SIZE {
+		return nil, fmt.Errorf("target size %d is too large", target_size)
+	}
+	pos += metadata_size
+
+	out := make([]byte, target_size)
+	out_pos, source_rel, target_rel := 0, 0, 0
+
+	for pos < end {
+		data, err := readVarint(patch, &pos)
+		if err != nil {
+			return nil, err
+		}
+		length := data>>2 + 1
+		if out_pos+length > len(out) {
+			return nil, fmt.Errorf("output overflow")
+		}
+
+		switch data & 0b11 {
+		case 0:
+			if out_pos+length > len(rom) {
+				return nil, fmt.Errorf("source overflow")
+			}
+			copy(out[out_pos:], rom[out_pos:out_pos+length])
+		case 1:
+			if pos+length > end {
+				return nil, fmt.Errorf("unexpected end of patch")
+			}
+			copy(out[out_pos:], patch[pos:pos+length])
+			pos += length
+		case 2, 3:
+			offset, err := readVarint(patch, &pos)
+			if err != nil {
+				return nil, err
+			}
+			if offset&0b1 == 1 {
+				offset = -(offset >> 1)
+			} else {
+				offset >>= 1
+			}
+
+			if data&0b11 == 2 {
+				source_rel += offset
+				if source_rel < 0 || source_rel+length > len(rom) {
+					return nil, fmt.Errorf("source overflow")
+				}
+				copy(out[out_pos:], rom[source_rel:source_rel+length])
+				source_rel += length
+			} else {
+				target_rel += offset
+				if target_rel < 0 || target_rel >= out_pos {
+					return nil, fmt.Errorf("target overflow")
+				}
+				// Byte by byte, the copy may overlap the output being written
+				for i := 0; i < length; i++ {
+					out[out_pos+i] = out[target_rel]
+					target_rel++
+				}
+			}
+		}
+		out_pos += length
+	}
+
+	if crc32.ChecksumIEEE(out) != target_crc {
+		return nil, fmt.Errorf("target checksum mismatch")
+	}
+	return out, nil
+}
@@ -0,0 +1,197 @@
+package casette
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Variable length number of UPS and BPS
+func encodeVarint(n int) []byte {
+	var out []byte
+	for {
+		x := byte(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			return append(out, 0x80|x)
+		}
+		out = append(out, x)
+		n--
+	}
+}
+
+// Append the UPS/BPS footer with the source, target and patch CRC32
+func withFooter(patch []byte, source []byte, target []byte) []byte {
+	crc := func(data []byte) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, crc32.ChecksumIEEE(data))
+		return b
+	}
+	patch = join(patch, crc(source), crc(target))
+	return join(patch, crc(patch))
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+type patchTest struct {
+	name  string
+	rom   []byte
+	patch []byte
+	want  []byte
+	err   bool
+}
+
+func runPatchTests(t *testing.T, tests []patchTest) {
+	t.Helper()
+	for _, tt := range tests {
+		got, err := applyPatch(tt.rom, tt.patch)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % X, want % X", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPatchIps(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+	runPatchTests(t, []patchTest{
+		{
+			name:  "record",
+			rom:   rom,
+			patch: join([]byte("PATCH"), []byte{0, 0, 2, 0, 2, 0xAA, 0xBB}, []byte("EOF")),
+			want:  []byte{0, 1, 0xAA, 0xBB, 4, 5, 6, 7},
+		},
+		{
+			name:  "RLE record",
+			rom:   rom,
+			patch: join([]byte("PATCH"), []byte{0, 0, 1, 0, 0, 0, 3, 0xCC}, []byte("EOF")),
+			want:  []byte{0, 0xCC, 0xCC, 0xCC, 4, 5, 6, 7},
+		},
+		{
+			name:  "record past the end",
+			rom:   rom,
+			patch: join([]byte("PATCH"), []byte{0, 0, 9, 0, 1, 0xDD}, []byte("EOF")),
+			want:  []byte{0, 1, 2, 3, 4, 5, 6, 7, 0, 0xDD},
+		},
+		{
+			name:  "truncate",
+			rom:   rom,
+			patch: join([]byte("PATCH"), []byte{0, 0, 0, 0, 1, 0xEE}, []byte("EOF"), []byte{0, 0, 4}),
+			want:  []byte{0xEE, 1, 2, 3},
+		},
+		{
+			name:  "no EOF",
+			rom:   rom,
+			patch: join([]byte("PATCH"), []byte{0, 0, 2, 0, 2, 0xAA, 0xBB}),
+			err:   true,
+		},
+		{
+			name:  "truncated record",
+			rom:   rom,
+			patch: join([]byte("PATCH"), []byte{0, 0, 2, 0, 4, 0xAA}),
+			err:   true,
+		},
+	})
+}
+
+func TestPatchUps(t *testing.T) {
+	source := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+	target := []byte{0, 1, 0x22, 3, 4, 5, 0x66, 7, 8}
+	// hunks: skip 2 and XOR 1 byte, the terminating 0 also moves one byte
+	body := join([]byte("UPS1"), encodeVarint(len(source)), encodeVarint(len(target)),
+		encodeVarint(2), []byte{2 ^ 0x22, 0},
+		encodeVarint(2), []byte{6 ^ 0x66, 0},
+		encodeVarint(0), []byte{8, 0})
+	patch := withFooter(body, source, target)
+
+	bad_crc := append([]byte(nil), patch...)
+	bad_crc[len(bad_crc)-1] ^= 0xFF
+
+	runPatchTests(t, []patchTest{
+		{name: "patch", rom: source, patch: patch, want: target},
+		{name: "bad patch CRC", rom: source, patch: bad_crc, err: true},
+		{name: "wrong source", rom: []byte{9, 9, 9, 9, 9, 9, 9, 9}, patch: patch, err: true},
+		{name: "bad target CRC", rom: source, patch: withFooter(body, source, source), err: true},
+		{name: "truncated", rom: source, patch: patch[:10], err: true},
+		{name: "truncated hunk", rom: source, patch: withFooter(body[:len(body)-1], source, target), err: true},
+		{name: "target too large", rom: source, patch: withFooter(join([]byte("UPS1"), encodeVarint(len(source)), encodeVarint(PATCH_MAX_SIZE+1)), source, target), err: true},
+	})
+}
+
+func TestPatchBps(t *testing.T) {
+	source := []byte("ABCDEFGH")
+	// SourceRead 2, TargetRead "xy", SourceCopy 3 from 5, TargetCopy 4 from 4
+	// (overlapping the bytes it writes), SourceCopy 1 from 0
+	target := []byte("ABxyFGHFGHFA")
+	action := func(kind int, length int) []byte {
+		return encodeVarint((length-1)<<2 | kind)
+	}
+	offset := func(rel int) []byte {
+		if rel < 0 {
+			return encodeVarint(-rel<<1 | 1)
+		}
+		return encodeVarint(rel << 1)
+	}
+	body := join([]byte("BPS1"), encodeVarint(len(source)), encodeVarint(len(target)), encodeVarint(0),
+		action(0, 2),
+		action(1, 2), []byte("xy"),
+		action(2, 3), offset(5),
+		action(3, 4), offset(4),
+		action(2, 1), offset(-8))
+	patch := withFooter(body, source, target)
+
+	bad_crc := append([]byte(nil), patch...)
+	bad_crc[len(bad_crc)-5] ^= 0xFF
+
+	runPatchTests(t, []patchTest{
+		{name: "patch", rom: source, patch: patch, want: target},
+		{name: "bad target CRC", rom: source, patch: bad_crc, err: true},
+		{name: "wrong source", rom: []byte("ABCDEFGX"), patch: patch, err: true},
+		{name: "target too large", rom: source, patch: withFooter(join([]byte("BPS1"), encodeVarint(len(source)), encodeVarint(PATCH_MAX_SIZE+1), encodeVarint(0)), source, target), err: true},
+		{name: "truncated", rom: source, patch: patch[:8], err: true},
+		{name: "truncated action", rom: source, patch: withFooter(body[:len(body)-1], source, target), err: true},
+		{name: "target copy before output", rom: source, patch: withFooter(join(body[:7], action(3, 1), offset(0)), source, []byte{0}), err: true},
+	})
+}
+
+func TestApplyPatchesError(t *testing.T) {
+	Patches = []string{writeRom(t, "bad.ips", []byte("PATCH"))}
+	defer func() { Patches = nil }()
+	if err := SetRom(writeRom(t, "game.nes", inesImage(0, 1, 1))); err == nil {
+		t.Errorf("expected an error for a broken patch")
+	}
+}
+
+// Without -patch only the first patch with the ROM name is applied
+func TestApplyPatchesDefault(t *testing.T) {
+	dir := t.TempDir()
+	rom_path := filepath.Join(dir, "game.nes")
+	ips := join([]byte("PATCH"), []byte{0, 0, 1, 0, 1, 0xAA}, []byte("EOF"))
+	for name, data := range map[string][]byte{"game.ips": ips, "game.ups": []byte("UPS1 broken"), "other.ips": []byte("broken")} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := applyPatches(rom_path, []byte{0, 1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte{0, 0xAA, 2}) {
+		t.Errorf("got % X, want 00 AA 02", got)
+	}
+}
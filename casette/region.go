@@ -0,0 +1,78 @@
+package casette
+
+/*
+   Console timing
+   |-----------------------+---------+---------+---------|
+   |                       | NTSC    | PAL     | Dendy   |
+   |-----------------------+---------+---------+---------|
+   | CPU clock (Hz)        | 1789773 | 1662607 | 1773448 |
+   | PPU dots / CPU cycle  | 3       | 3.2     | 3       |
+   | Scanlines             | 262     | 312     | 312     |
+   | Vblank (NMI) line     | 241     | 241     | 291     |
+   | Vblank lines          | 20      | 70      | 20      |
+   | Emphasis red/green    |         | swapped | swapped |
+   | Odd frame dot skip    | yes     |         |         |
+   |-----------------------+---------+---------+---------|
+   Multi-region games run as NTSC. PAL and Dendy use the same palettes as
+   NTSC with the red and green emphasis bits of PPUMASK swapped, the other
+   color differences of their PPUs are not emulated. There is no APU, so
+   the APU noise and DMC rate tables of the regions are not kept here.
+*/
+type Timing struct {
+	CpuClock int
+	// PPU dots per CPU cycle is DotsNum / DotsDen
+	DotsNum int
+	DotsDen int
+
+	Scanlines  int
+	VblankLine int
+
+	// Frame counter step (240 Hz) in CPU cycles, used by the MMC5 pulses
+	FrameCycles int
+
+	SwapEmphasis bool
+	SkipOddDot   bool
+}
+
+var Timings = [...]Timing{
+	REGION_NTSC: {
+		CpuClock:    1789773,
+		DotsNum:     3,
+		DotsDen:     1,
+		Scanlines:   262,
+		VblankLine:  241,
+		FrameCycles: 7457,
+		SkipOddDot:  true,
+	},
+	REGION_PAL: {
+		CpuClock:     1662607,
+		DotsNum:      16,
+		DotsDen:      5,
+		Scanlines:    312,
+		VblankLine:   241,
+		FrameCycles:  8313,
+		SwapEmphasis: true,
+	},
+	REGION_DENDY: {
+		CpuClock:     1773448,
+		DotsNum:      3,
+		DotsDen:      1,
+		Scanlines:    312,
+		VblankLine:   291,
+		FrameCycles:  7457,
+		SwapEmphasis: true,
+	},
+}
+
+// Region of the console and its timing
+var Region byte
+var Console = Timings[REGION_NTSC]
+
+// Select the console region, multi-region runs as NTSC
+func SetRegion(region byte) {
+	if region == REGION_MULTI || int(region) >= len(Timings) {
+		region = REGION_NTSC
+	}
+	Region = region
+	Console = Timings[region]
+}
@@ -0,0 +1,92 @@
+package casette
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Directory of save files, empty to save next to the ROM
+var Save_dir string
+
+var save_path string
+var save_mem []byte
+var saved_mem []byte
+
+// Conversion between the memory and the save file, nil to save it as is
+var save_encode func(mem []byte) []byte
+var save_decode func(data []byte, mem []byte)
+
+// Path of a save file with ext for the ROM
+func savePath(rom_path string, ext string) string {
+	dir := Save_dir
+	if dir == "" {
+		dir = filepath.Dir(rom_path)
+	}
+	name := filepath.Base(romBase(rom_path))
+	return filepath.Join(dir, name+ext)
+}
+
+// Load persistent memory (battery-backed PRG RAM, FDS disk) from the save
+// file with ext
+func loadSave(rom_path string, ext string, mem []byte) {
+	save_path = savePath(rom_path, ext)
+	save_mem = mem
+
+	data, err := ioutil.ReadFile(save_path)
+	switch {
+	case err == nil && save_decode != nil:
+		save_decode(data, mem)
+	case err == nil:
+		copy(mem, data)
+	case !os.IsNotExist(err):
+		fmt.Printf("Cannot read %s\n", save_path)
+	}
+	saved_mem = append([]byte(nil), mem...)
+}
+
+// Write persistent memory to the save file if it has changed
+func FlushSave() error {
+	if save_path == "" || bytes.Equal(saved_mem, save_mem) {
+		return nil
+	}
+	data := save_mem
+	if save_encode != nil {
+		data = save_encode(save_mem)
+	}
+	if err := writeFileAtomic(save_path, data); err != nil {
+		return err
+	}
+	saved_mem = append(saved_mem[:0], save_mem...)
+	return nil
+}
+
+// Write to a temporary file and rename it, so a crash never leaves a
+// partially written file behind
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
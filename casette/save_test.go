@@ -0,0 +1,73 @@
+package casette
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveRoundTrip(t *testing.T) {
+	// Battery-backed NROM with a trainer
+	rom := inesImage(0, 1, 1)
+	rom[6] |= 0b110
+	trainer_data := bytes.Repeat([]byte{0xAA}, 0x200)
+	rom = append(append(rom[:16:16], trainer_data...), rom[16:]...)
+	path := writeRom(t, "game.nes", rom)
+
+	Save_dir = filepath.Join(t.TempDir(), "saves")
+	defer func() { Save_dir = "" }()
+	sav := filepath.Join(Save_dir, "game.sav")
+
+	if err := SetRom(path); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(Prg_ram[0x1000:0x1200], trainer_data) {
+		t.Errorf("trainer not at $7000 without a save")
+	}
+
+	// Nothing is written until the RAM changes
+	if err := FlushSave(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadFile(sav); err == nil {
+		t.Errorf("%s written without changes", sav)
+	}
+
+	Prg_ram[0x0000] = 0x42
+	Prg_ram[0x1000] = 0x55
+	if err := FlushSave(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(sav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, Prg_ram) {
+		t.Errorf("save file is not the PRG RAM")
+	}
+	// The temporary file is renamed to the save file
+	if files, _ := ioutil.ReadDir(Save_dir); len(files) != 1 {
+		t.Errorf("%d files in the save directory, want 1", len(files))
+	}
+
+	// The save replaces the trainer on the next load
+	if err := SetRom(path); err != nil {
+		t.Fatal(err)
+	}
+	if Prg_ram[0x0000] != 0x42 || Prg_ram[0x1000] != 0x55 || Prg_ram[0x1001] != 0xAA {
+		t.Errorf("PRG RAM $%02X $%02X $%02X after reload, want $42 $55 $AA", Prg_ram[0x0000], Prg_ram[0x1000], Prg_ram[0x1001])
+	}
+
+	// A ROM without battery has nothing to save
+	if err := SetRom(writeRom(t, "other.nes", inesImage(0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	Prg_ram[0] = 0x99
+	if err := FlushSave(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(sav); data[0] != 0x42 {
+		t.Errorf("save of the previous ROM overwritten")
+	}
+}
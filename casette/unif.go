@@ -0,0 +1,212 @@
+package casette
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+/*
+   UNIF
+   Header (32 bytes)
+   0-3:   Constant "UNIF"
+   4-7:   Revision (little endian)
+   8-31:  Reserved
+   Chunks follow the header
+   0-3:   Chunk ID
+   4-7:   Length of the data (little endian)
+   8-:    Data
+
+   Chunks
+   |-----------+----------------------------------------------------------|
+   | ID        | Notes                                                    |
+   |-----------+----------------------------------------------------------|
+   | MAPR      | board name, null terminated                              |
+   | NAME      | game name, null terminated                               |
+   | PRG0-PRGF | PRG ROM, concatenated in order                           |
+   | CHR0-CHRF | CHR ROM, concatenated in order                           |
+   | MIRR      | mirroring                                                |
+   | BATR      | battery-backed PRG RAM if non-zero                       |
+   | TVCI      | TV system (0: NTSC; 1: PAL; 2: both)                     |
+   |-----------+----------------------------------------------------------|
+
+   MIRR
+   |-------+--------------------------|
+   | Value | Mirroring                |
+   |-------+--------------------------|
+   |     0 | horizontal               |
+   |     1 | vertical                 |
+   |     2 | single A ($2000)         |
+   |     3 | single B ($2400)         |
+   |     4 | four screen              |
+   |     5 | controlled by the mapper |
+   |-------+--------------------------|
+*/
+func loadUnif(rom []byte) error {
+	HEADER_SIZE := 0x0020
+
+	if len(rom) < HEADER_SIZE {
+		return fmt.Errorf("ROM is truncated")
+	}
+
+	cart := new(Cartridge)
+	cart.PrgRamSize = 0x2000
+	cart.ChrRamSize = 0x2000
+
+	var prg [16][]byte
+	var chr [16][]byte
+	for pos := HEADER_SIZE; pos < len(rom); {
+		if pos+8 > len(rom) {
+			return fmt.Errorf("chunk header is truncated")
+		}
+		id := string(rom[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(rom[pos+4:]))
+		pos += 8
+		if size > len(rom)-pos {
+			return fmt.Errorf("chunk %s is truncated", id)
+		}
+		data := rom[pos : pos+size]
+		pos += size
+
+		switch {
+		case id == "MAPR":
+			cart.Board = unifString(data)
+		case id == "NAME":
+			cart.Name = unifString(data)
+		case id == "MIRR" && size > 0:
+			switch data[0] {
+			case 0:
+				cart.Mirroring = MIRROR_HORIZONTAL
+			case 1:
+				cart.Mirroring = MIRROR_VERTICAL
+			case 2:
+				cart.Mirroring = MIRROR_SINGLE_A
+			case 3:
+				cart.Mirroring = MIRROR_SINGLE_B
+			case 4:
+				cart.Mirroring = MIRROR_FOUR_SCREEN
+			}
+		case id == "BATR" && size > 0:
+			cart.Battery = data[0] != 0
+		case id == "TVCI" && size > 0:
+			switch data[0] {
+			case 1:
+				cart.Region = REGION_PAL
+			case 2:
+				cart.Region = REGION_MULTI
+			}
+		case strings.HasPrefix(id, "PRG"):
+			if n := unifChunkIndex(id); n >= 0 {
+				prg[n] = data
+			}
+		case strings.HasPrefix(id, "CHR"):
+			if n := unifChunkIndex(id); n >= 0 {
+				chr[n] = data
+			}
+		}
+	}
+
+	board, ok := unifBoard(cart.Board)
+	if !ok {
+		return fmt.Errorf("board %s is not supported", cart.Board)
+	}
+	cart.Mapper = board.mapper
+	cart.Submapper = board.submapper
+	if board.four_screen {
+		cart.Mirroring = MIRROR_FOUR_SCREEN
+	}
+
+	if len(prg[0]) == 0 {
+		return fmt.Errorf("no PRG0 chunk")
+	}
+
+	Cart = cart
+	Prg_rom = bytes.Join(prg[:], nil)
+	Chr_rom = bytes.Join(chr[:], nil)
+	return nil
+}
+
+// Index of PRG0-PRGF and CHR0-CHRF chunks
+func unifChunkIndex(id string) int {
+	c := id[3]
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
+
+func unifString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+type unifMapper struct {
+	mapper      int
+	submapper   int
+	four_screen bool
+}
+
+/*
+   UNIF boards
+   |------------------------------------------+--------+-------------|
+   | Board                                    | Mapper | Notes       |
+   |------------------------------------------+--------+-------------|
+   | NROM, NROM-128, NROM-256, HROM, RROM,    |      0 |             |
+   | RROM-128                                 |        |             |
+   | UNROM, UOROM                             |      2 |             |
+   | TBROM, TEROM, TFROM, TGROM, TKROM,       |      4 |             |
+   | TLROM, TL1ROM, TNROM, TSROM, B4          |        |             |
+   | TR1ROM, TVROM                            |      4 | four screen |
+   | HKROM                                    |    4.1 | MMC6        |
+   | EKROM, ELROM, ETROM, EWROM               |      5 |             |
+   | PNROM, PEEOROM                           |      9 |             |
+   | FJROM, FKROM                             |     10 |             |
+   |------------------------------------------+--------+-------------|
+   The NES-, HVC-, UNL- and BMC- prefixes of board names are ignored
+*/
+var unif_boards = map[string]unifMapper{
+	"NROM":     {0, 0, false},
+	"NROM-128": {0, 0, false},
+	"NROM-256": {0, 0, false},
+	"HROM":     {0, 0, false},
+	"RROM":     {0, 0, false},
+	"RROM-128": {0, 0, false},
+	"UNROM":    {2, 0, false},
+	"UOROM":    {2, 0, false},
+	"TBROM":    {4, 0, false},
+	"TEROM":    {4, 0, false},
+	"TFROM":    {4, 0, false},
+	"TGROM":    {4, 0, false},
+	"TKROM":    {4, 0, false},
+	"TLROM":    {4, 0, false},
+	"TL1ROM":   {4, 0, false},
+	"TNROM":    {4, 0, false},
+	"TSROM":    {4, 0, false},
+	"B4":       {4, 0, false},
+	"TR1ROM":   {4, 0, true},
+	"TVROM":    {4, 0, true},
+	"HKROM":    {4, 1, false},
+	"EKROM":    {5, 0, false},
+	"ELROM":    {5, 0, false},
+	"ETROM":    {5, 0, false},
+	"EWROM":    {5, 0, false},
+	"PNROM":    {9, 0, false},
+	"PEEOROM":  {9, 0, false},
+	"FJROM":    {10, 0, false},
+	"FKROM":    {10, 0, false},
+}
+
+func unifBoard(name string) (unifMapper, bool) {
+	name = strings.ToUpper(name)
+	for _, prefix := range []string{"NES-", "HVC-", "UNL-", "BMC-"} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	board, ok := unif_boards[name]
+	return board, ok
+}
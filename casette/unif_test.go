@@ -0,0 +1,115 @@
+package casette
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type unifChunk struct {
+	id   string
+	data []byte
+}
+
+// UNIF image with the chunks in order
+func unifImage(chunks ...unifChunk) []byte {
+	rom := make([]byte, 0x20)
+	copy(rom, "UNIF")
+	binary.LittleEndian.PutUint32(rom[4:], 7)
+	for _, c := range chunks {
+		size := make([]byte, 4)
+		binary.LittleEndian.PutUint32(size, uint32(len(c.data)))
+		rom = append(rom, c.id...)
+		rom = append(rom, size...)
+		rom = append(rom, c.data...)
+	}
+	return rom
+}
+
+func TestLoadUnif(t *testing.T) {
+	prg0 := bytes.Repeat([]byte{0xA0}, 0x4000)
+	prg1 := bytes.Repeat([]byte{0xA1}, 0x4000)
+	chr0 := bytes.Repeat([]byte{0xC0}, 0x2000)
+	rom := unifImage(
+		unifChunk{"MAPR", []byte("NES-TVROM\x00")},
+		unifChunk{"NAME", []byte("Game\x00")},
+		unifChunk{"PRG1", prg1},
+		unifChunk{"PRG0", prg0},
+		unifChunk{"CHR0", chr0},
+		unifChunk{"MIRR", []byte{1}},
+		unifChunk{"TVCI", []byte{1}},
+		unifChunk{"DINF", make([]byte, 204)},
+	)
+	if err := SetRom(writeRom(t, "game.unf", rom)); err != nil {
+		t.Fatal(err)
+	}
+
+	if Cart.Board != "NES-TVROM" || Cart.Name != "Game" {
+		t.Errorf("board %q name %q, want NES-TVROM Game", Cart.Board, Cart.Name)
+	}
+	if Cart.Mapper != 4 || Cart.Submapper != 0 {
+		t.Errorf("mapper %d.%d, want 4.0", Cart.Mapper, Cart.Submapper)
+	}
+	if Cart.Mirroring != MIRROR_FOUR_SCREEN {
+		t.Errorf("mirroring %d, want four screen for TVROM", Cart.Mirroring)
+	}
+	if Cart.Region != REGION_PAL {
+		t.Errorf("region %d, want PAL", Cart.Region)
+	}
+	if !bytes.Equal(Prg_rom, append(append([]byte{}, prg0...), prg1...)) {
+		t.Errorf("PRG ROM is not PRG0 followed by PRG1")
+	}
+	if !bytes.Equal(Chr_rom, chr0) || Chr_ram != nil {
+		t.Errorf("CHR ROM is not CHR0")
+	}
+	if _, ok := Cart_mapper.(*mmc3); !ok {
+		t.Errorf("mapper is %T, want *mmc3", Cart_mapper)
+	}
+}
+
+func TestLoadUnifError(t *testing.T) {
+	prg := make([]byte, 0x8000)
+	tests := []struct {
+		name string
+		rom  []byte
+		err  string
+	}{
+		{"unknown board", unifImage(unifChunk{"MAPR", []byte("UNL-FOO\x00")}, unifChunk{"PRG0", prg}), "board UNL-FOO is not supported"},
+		{"no board", unifImage(unifChunk{"PRG0", prg}), "board  is not supported"},
+		{"no PRG0", unifImage(unifChunk{"MAPR", []byte("NROM\x00")}, unifChunk{"PRG1", prg}), "no PRG0 chunk"},
+		{"empty PRG0", unifImage(unifChunk{"MAPR", []byte("NROM\x00")}, unifChunk{"PRG0", nil}), "no PRG0 chunk"},
+		{"truncated header", []byte("UNIF"), "ROM is truncated"},
+		{"truncated chunk", unifImage(unifChunk{"MAPR", []byte("NROM\x00")}, unifChunk{"PRG0", prg})[:0x40], "chunk PRG0 is truncated"},
+		{"truncated chunk header", append(unifImage(unifChunk{"MAPR", []byte("NROM\x00")}), "PRG0"...), "chunk header is truncated"},
+	}
+	for _, tt := range tests {
+		err := SetRom(writeRom(t, "game.unf", tt.rom))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestUnifBoard(t *testing.T) {
+	tests := []struct {
+		name string
+		want unifMapper
+		ok   bool
+	}{
+		{"NES-NROM-256", unifMapper{0, 0, false}, true},
+		{"HVC-HROM", unifMapper{0, 0, false}, true},
+		{"UNL-UOROM", unifMapper{2, 0, false}, true},
+		{"BMC-TLROM", unifMapper{4, 0, false}, true},
+		{"nes-tvrom", unifMapper{4, 0, true}, true},
+		{"NES-HKROM", unifMapper{4, 1, false}, true},
+		{"BMC-FOO", unifMapper{}, false},
+		{"SNROM", unifMapper{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := unifBoard(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %+v %t, want %+v %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
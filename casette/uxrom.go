@@ -0,0 +1,44 @@
+package casette
+
+/*
+   UxROM (mapper 2)
+   |---------------+-------+----------------------------------------------|
+   | Address range | Bank  | Notes                                        |
+   |---------------+-------+----------------------------------------------|
+   | $8000-$BFFF   | 16 KB | switchable by writes to $8000-$FFFF          |
+   | $C000-$FFFF   | 16 KB | last bank                                    |
+   |---------------+-------+----------------------------------------------|
+   CHR is 8 KB of CHR RAM
+*/
+type uxrom struct {
+	baseMapper
+	prg_bank byte
+}
+
+func newUxrom() *uxrom {
+	return new(uxrom)
+}
+
+func (m *uxrom) CpuRead(addr uint16) byte {
+	switch {
+	case addr >= 0xC000:
+		return readBank(Prg_rom, -1, 0x4000, addr)
+	case addr >= 0x8000:
+		return readBank(Prg_rom, int(m.prg_bank), 0x4000, addr)
+	}
+	return byte(addr >> 8)
+}
+
+func (m *uxrom) CpuWrite(addr uint16, data byte) {
+	if addr >= 0x8000 {
+		m.prg_bank = data
+	}
+}
+
+func (m *uxrom) PpuRead(addr uint16) byte {
+	return readChr(0, 0x2000, addr)
+}
+
+func (m *uxrom) PpuWrite(addr uint16, data byte) {
+	writeChr(0, 0x2000, addr, data)
+}
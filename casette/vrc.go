@@ -0,0 +1,284 @@
+package casette
+
+import "fmt"
+
+/*
+   VRC2/VRC4 (mapper 21, 22, 23, 25)
+   |---------------+------+---------------------------------------------|
+   | Address range | Bank | Notes                                       |
+   |---------------+------+---------------------------------------------|
+   | $6000-$7FFF   |      | PRG RAM (VRC2 without RAM: 1 bit latch)     |
+   | $8000-$9FFF   | 8 KB | R0 or second last bank (VRC4 PRG mode)      |
+   | $A000-$BFFF   | 8 KB | R1                                          |
+   | $C000-$DFFF   | 8 KB | second last bank or R0 (VRC4 PRG mode)      |
+   | $E000-$FFFF   | 8 KB | last bank                                   |
+   |---------------+------+---------------------------------------------|
+
+   Registers (A1 A0 after the board wiring)
+   |-------------+---------------------------------------------------------|
+   | Address     | Notes                                                   |
+   |-------------+---------------------------------------------------------|
+   | $8000-$8003 | PRG bank R0                                             |
+   | $9000       | mirroring                                               |
+   | $9002       | VRC4: bit 1 PRG mode                                    |
+   | $A000-$A003 | PRG bank R1                                             |
+   | $B000-$E003 | CHR banks 0-7, low nibble (A0=0) and high nibble (A0=1) |
+   | $F000-$F001 | VRC4: IRQ latch low/high nibble                         |
+   | $F002       | VRC4: IRQ control                                       |
+   | $F003       | VRC4: IRQ acknowledge                                   |
+   |-------------+---------------------------------------------------------|
+
+   Board wiring (CPU address line of VRC A0, A1)
+   |--------+-----------+-------+---------|
+   | Mapper | Submapper | Chip  | A0, A1  |
+   |--------+-----------+-------+---------|
+   |     21 |         1 | VRC4a | A1, A2  |
+   |     21 |         2 | VRC4c | A6, A7  |
+   |     22 |         0 | VRC2a | A1, A0  |
+   |     23 |         1 | VRC4f | A0, A1  |
+   |     23 |         2 | VRC4e | A2, A3  |
+   |     23 |         3 | VRC2b | A0, A1  |
+   |     25 |         1 | VRC4b | A1, A0  |
+   |     25 |         2 | VRC4d | A3, A2  |
+   |     25 |         3 | VRC2c | A1, A0  |
+   |--------+-----------+-------+---------|
+   Submapper 0 of mapper 21, 23 and 25 combines both VRC4 wirings, so do
+   unknown submappers.
+*/
+type vrc struct {
+	baseMapper
+	vrc2 bool
+	// VRC2 board without PRG RAM, $6000-$6FFF is a 1 bit latch
+	no_ram bool
+	// VRC2a ignores the lowest CHR bank bit
+	chr_shift uint
+	wiring    [][2]uint
+
+	prg_banks [2]byte
+	chr_banks [8]uint16
+	prg_mode  byte
+	mirroring byte
+	latch     byte
+
+	irq vrcIrq
+}
+
+func newVrc(mapper int, submapper int) *vrc {
+	m := new(vrc)
+	m.mirroring = Cart.Mirroring
+
+	switch mapper {
+	case 21:
+		m.wiring = [][2]uint{{1, 2}, {6, 7}}
+	case 22:
+		m.wiring = [][2]uint{{1, 0}}
+		m.vrc2 = true
+		m.chr_shift = 1
+	case 23:
+		m.wiring = [][2]uint{{0, 1}, {2, 3}}
+		m.vrc2 = submapper == 3
+	case 25:
+		m.wiring = [][2]uint{{1, 0}, {3, 2}}
+		m.vrc2 = submapper == 3
+	}
+	switch {
+	case submapper == 0 || len(m.wiring) == 1:
+	case submapper == 3 && m.vrc2:
+		m.wiring = m.wiring[:1]
+	case submapper <= 2:
+		m.wiring = m.wiring[submapper-1 : submapper]
+	default:
+		Cart.Warnings = append(Cart.Warnings, fmt.Sprintf("unknown submapper %d of mapper %d, both VRC4 wirings are used", submapper, mapper))
+	}
+	m.no_ram = m.vrc2 && Cart.PrgRamSize == 0
+	return m
+}
+
+// Translate CPU address to the VRC register address $X000-$X003
+func (m *vrc) register(addr uint16) uint16 {
+	var pins uint16
+	for _, w := range m.wiring {
+		pins |= (addr>>w[0]&0b1)<<0 | (addr>>w[1]&0b1)<<1
+	}
+	return addr&0xF000 | pins
+}
+
+func (m *vrc) CpuRead(addr uint16) byte {
+	switch {
+	case addr >= 0x8000:
+		slot := int(addr-0x8000) / 0x2000
+		if m.prg_mode == 1 && slot != 1 {
+			slot = 2 - slot
+		}
+		bank := -1
+		switch slot {
+		case 0, 1:
+			bank = int(m.prg_banks[slot] & 0x1F)
+		case 2:
+			bank = -2
+		}
+		return readBank(Prg_rom, bank, 0x2000, addr)
+	case addr >= 0x6000:
+		if m.no_ram {
+			if addr < 0x7000 {
+				return byte(addr>>8)&0xFE | m.latch
+			}
+			return byte(addr >> 8)
+		}
+		return Prg_ram[addr-0x6000]
+	}
+	return byte(addr >> 8)
+}
+
+func (m *vrc) CpuWrite(addr uint16, data byte) {
+	if addr < 0x8000 {
+		if addr >= 0x6000 {
+			if m.no_ram {
+				m.latch = data & 0b1
+			} else {
+				Prg_ram[addr-0x6000] = data
+			}
+		}
+		return
+	}
+
+	reg := m.register(addr)
+	switch {
+	case reg < 0x9000:
+		m.prg_banks[0] = data
+	case reg < 0xA000:
+		switch {
+		case m.vrc2:
+			m.mirroring = MIRROR_VERTICAL - data&0b1
+		case reg == 0x9000:
+			m.mirroring = vrcMirroring(data)
+		case reg == 0x9002:
+			m.prg_mode = data >> 1 & 0b1
+		}
+	case reg < 0xB000:
+		m.prg_banks[1] = data
+	case reg < 0xF000:
+		bank := (reg-0xB000)>>12*2 + reg>>1&0b1
+		if reg&0b1 == 0 {
+			m.chr_banks[bank] = m.chr_banks[bank]&0x1F0 | uint16(data&0x0F)
+		} else {
+			m.chr_banks[bank] = m.chr_banks[bank]&0x00F | uint16(data&0x1F)<<4
+		}
+	case !m.vrc2:
+		switch reg & 0b11 {
+		case 0:
+			m.irq.latch = m.irq.latch&0xF0 | data&0x0F
+		case 1:
+			m.irq.latch = m.irq.latch&0x0F | data<<4
+		case 2:
+			m.irq.writeControl(data)
+		case 3:
+			m.irq.ack()
+		}
+	}
+}
+
+func (m *vrc) chrBank(addr uint16) int {
+	return int(m.chr_banks[addr/0x0400] >> m.chr_shift)
+}
+
+func (m *vrc) PpuRead(addr uint16) byte {
+	return readChr(m.chrBank(addr), 0x0400, addr)
+}
+
+func (m *vrc) PpuWrite(addr uint16, data byte) {
+	writeChr(m.chrBank(addr), 0x0400, addr, data)
+}
+
+func (m *vrc) Clock() {
+	m.irq.clock()
+}
+
+func (m *vrc) Irq() bool {
+	return m.irq.flag
+}
+
+/*
+   VRC4/6/7 mirroring
+   |-------+--------------|
+   | Value | Mirroring    |
+   |-------+--------------|
+   |     0 | Vertical     |
+   |     1 | Horizontal   |
+   |     2 | One screen A |
+   |     3 | One screen B |
+   |-------+--------------|
+*/
+func vrcMirroring(data byte) byte {
+	switch data & 0b11 {
+	case 0:
+		return MIRROR_VERTICAL
+	case 1:
+		return MIRROR_HORIZONTAL
+	case 2:
+		return MIRROR_SINGLE_A
+	default:
+		return MIRROR_SINGLE_B
+	}
+}
+
+/*
+   VRC IRQ control
+   |-----+-------------------------------------------|
+   | bit | Notes                                     |
+   |-----+-------------------------------------------|
+   |   2 | mode (0: scanline, 1: CPU cycle)          |
+   |   1 | enable                                    |
+   |   0 | enable after acknowledgement              |
+   |-----+-------------------------------------------|
+   In scanline mode a prescaler divides the CPU clock by 113.667 (341/3).
+*/
+type vrcIrq struct {
+	latch            byte
+	counter          byte
+	prescaler        int
+	enable           bool
+	enable_after_ack bool
+	cycle_mode       bool
+	flag             bool
+}
+
+func (q *vrcIrq) writeControl(data byte) {
+	q.enable_after_ack = data>>0&0b1 == 1
+	q.enable = data>>1&0b1 == 1
+	q.cycle_mode = data>>2&0b1 == 1
+	q.flag = false
+	if q.enable {
+		q.counter = q.latch
+		q.prescaler = 341
+	}
+}
+
+func (q *vrcIrq) ack() {
+	q.flag = false
+	q.enable = q.enable_after_ack
+}
+
+func (q *vrcIrq) clock() {
+	if !q.enable {
+		return
+	}
+	if !q.cycle_mode {
+		q.prescaler -= 3
+		if q.prescaler > 0 {
+			return
+		}
+		q.prescaler += 341
+	}
+
+	if q.counter == 0xFF {
+		q.counter = q.latch
+		q.flag = true
+	} else {
+		q.counter++
+	}
+}
+
+func (m *vrc) Mirroring() [4]byte {
+	return mirrorPages(m.mirroring)
+}
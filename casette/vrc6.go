@@ -0,0 +1,302 @@
+package casette
+
+/*
+   VRC6 (mapper 24: VRC6a, mapper 26: VRC6b with A0 and A1 swapped)
+   |---------------+-------+--------------------------------------|
+   | Address range | Bank  | Notes                                |
+   |---------------+-------+--------------------------------------|
+   | $6000-$7FFF   |       | PRG RAM, enabled by $B003 bit 7      |
+   | $8000-$BFFF   | 16 KB | $8000                                |
+   | $C000-$DFFF   |  8 KB | $C000                                |
+   | $E000-$FFFF   |  8 KB | last bank                            |
+   |---------------+-------+--------------------------------------|
+
+   Registers
+   |-------------+-----------------------------------------------|
+   | Address     | Notes                                         |
+   |-------------+-----------------------------------------------|
+   | $9000-$9002 | pulse 1                                       |
+   | $9003       | frequency scaling and halt                    |
+   | $A000-$A002 | pulse 2                                       |
+   | $B000-$B002 | sawtooth                                      |
+   | $B003       | PPU banking style, mirroring, PRG RAM enable  |
+   | $D000-$E003 | CHR banks R0-R7                               |
+   | $F000       | IRQ latch                                     |
+   | $F001       | IRQ control                                   |
+   | $F002       | IRQ acknowledge                               |
+   |-------------+-----------------------------------------------|
+*/
+type vrc6 struct {
+	baseMapper
+	swap bool
+
+	prg_16k   byte
+	prg_8k    byte
+	chr_banks [8]byte
+	banking   byte
+	mirroring byte
+
+	irq vrcIrq
+
+	pulses [2]vrc6Pulse
+	saw    vrc6Saw
+	halt   bool
+	shift  uint
+}
+
+func newVrc6(mapper int) *vrc6 {
+	m := new(vrc6)
+	m.swap = mapper == 26
+	m.mirroring = Cart.Mirroring
+	return m
+}
+
+func (m *vrc6) CpuRead(addr uint16) byte {
+	switch {
+	case addr >= 0xE000:
+		return readBank(Prg_rom, -1, 0x2000, addr)
+	case addr >= 0xC000:
+		return readBank(Prg_rom, int(m.prg_8k&0x1F), 0x2000, addr)
+	case addr >= 0x8000:
+		return readBank(Prg_rom, int(m.prg_16k&0x0F), 0x4000, addr)
+	case addr >= 0x6000:
+		if m.banking>>7&0b1 == 1 {
+			return Prg_ram[addr-0x6000]
+		}
+	}
+	return byte(addr >> 8)
+}
+
+func (m *vrc6) CpuWrite(addr uint16, data byte) {
+	if addr < 0x8000 {
+		if addr >= 0x6000 && m.banking>>7&0b1 == 1 {
+			Prg_ram[addr-0x6000] = data
+		}
+		return
+	}
+
+	reg := addr & 0xF003
+	if m.swap {
+		reg = reg&0xF000 | (reg&0b01)<<1 | (reg&0b10)>>1
+	}
+	switch reg & 0xF000 {
+	case 0x8000:
+		m.prg_16k = data
+	case 0x9000:
+		if reg == 0x9003 {
+			m.halt = data>>0&0b1 == 1
+			switch {
+			case data>>2&0b1 == 1:
+				m.shift = 8
+			case data>>1&0b1 == 1:
+				m.shift = 4
+			default:
+				m.shift = 0
+			}
+		} else {
+			m.pulses[0].write(reg&0b11, data)
+		}
+	case 0xA000:
+		m.pulses[1].write(reg&0b11, data)
+	case 0xB000:
+		if reg == 0xB003 {
+			m.banking = data
+			m.mirroring = vrcMirroring(data >> 2)
+		} else {
+			m.saw.write(reg&0b11, data)
+		}
+	case 0xC000:
+		m.prg_8k = data
+	case 0xD000:
+		m.chr_banks[reg&0b11] = data
+	case 0xE000:
+		m.chr_banks[4+reg&0b11] = data
+	case 0xF000:
+		switch reg & 0b11 {
+		case 0:
+			m.irq.latch = data
+		case 1:
+			m.irq.writeControl(data)
+		case 2:
+			m.irq.ack()
+		}
+	}
+}
+
+/*
+   $B003 PPU banking style (bit 0-1)
+   |------+--------------------------------------------------|
+   | Mode | CHR layout                                       |
+   |------+--------------------------------------------------|
+   |    0 | 8 x 1 KB (R0-R7)                                 |
+   |    1 | 4 x 2 KB (R0-R3)                                 |
+   |  2-3 | 4 x 1 KB (R0-R3) and 2 x 2 KB (R4-R5)            |
+   |------+--------------------------------------------------|
+*/
+func (m *vrc6) chrBank(addr uint16) (bank int, size int) {
+	slot := addr / 0x0400
+	switch m.banking & 0b11 {
+	case 0:
+		return int(m.chr_banks[slot]), 0x0400
+	case 1:
+		return int(m.chr_banks[slot/2]), 0x0800
+	default:
+		if slot < 4 {
+			return int(m.chr_banks[slot]), 0x0400
+		}
+		return int(m.chr_banks[4+(slot-4)/2]), 0x0800
+	}
+}
+
+func (m *vrc6) PpuRead(addr uint16) byte {
+	bank, size := m.chrBank(addr)
+	return readChr(bank, size, addr)
+}
+
+func (m *vrc6) PpuWrite(addr uint16, data byte) {
+	bank, size := m.chrBank(addr)
+	writeChr(bank, size, addr, data)
+}
+
+func (m *vrc6) Clock() {
+	m.irq.clock()
+	if m.halt {
+		return
+	}
+	m.pulses[0].clock(m.shift)
+	m.pulses[1].clock(m.shift)
+	m.saw.clock(m.shift)
+}
+
+func (m *vrc6) Irq() bool {
+	return m.irq.flag
+}
+
+// Mix pulse (0-15) x2 and sawtooth (0-31) channels
+func (m *vrc6) Output() float32 {
+	out := m.pulses[0].output() + m.pulses[1].output() + m.saw.output()
+	return float32(out) / 61
+}
+
+/*
+   VRC6 pulse
+   |----------+-----------+-------------------------------------|
+   | Register | Bits      | Notes                               |
+   |----------+-----------+-------------------------------------|
+   | $X000    | MDDD VVVV | mode (ignore duty), duty, volume    |
+   | $X001    | PPPP PPPP | period low                          |
+   | $X002    | E--- PPPP | enable, period high                 |
+   |----------+-----------+-------------------------------------|
+*/
+type vrc6Pulse struct {
+	control byte
+	period  uint16
+	enable  bool
+	timer   uint16
+	step    byte
+}
+
+func (p *vrc6Pulse) write(reg uint16, data byte) {
+	switch reg {
+	case 0:
+		p.control = data
+	case 1:
+		p.period = p.period&0x0F00 | uint16(data)
+	case 2:
+		p.period = p.period&0x00FF | uint16(data&0x0F)<<8
+		p.enable = data>>7&0b1 == 1
+		if !p.enable {
+			p.step = 15
+		}
+	}
+}
+
+func (p *vrc6Pulse) clock(shift uint) {
+	if !p.enable {
+		return
+	}
+	if p.timer == 0 {
+		p.timer = p.period >> shift
+		p.step = (p.step - 1) & 0x0F
+	} else {
+		p.timer--
+	}
+}
+
+func (p *vrc6Pulse) output() int {
+	if !p.enable {
+		return 0
+	}
+	duty := p.control >> 4 & 0b111
+	if p.control>>7&0b1 == 1 || p.step <= duty {
+		return int(p.control & 0x0F)
+	}
+	return 0
+}
+
+/*
+   VRC6 sawtooth
+   |----------+-----------+-------------------------------------|
+   | Register | Bits      | Notes                               |
+   |----------+-----------+-------------------------------------|
+   | $B000    | --AA AAAA | accumulator rate                    |
+   | $B001    | PPPP PPPP | period low                          |
+   | $B002    | E--- PPPP | enable, period high                 |
+   |----------+-----------+-------------------------------------|
+   The accumulator is increased on every second of 14 steps and the
+   upper 5 bits are output.
+*/
+type vrc6Saw struct {
+	rate        byte
+	period      uint16
+	enable      bool
+	timer       uint16
+	step        byte
+	accumulator byte
+}
+
+func (s *vrc6Saw) write(reg uint16, data byte) {
+	switch reg {
+	case 0:
+		s.rate = data & 0x3F
+	case 1:
+		s.period = s.period&0x0F00 | uint16(data)
+	case 2:
+		s.period = s.period&0x00FF | uint16(data&0x0F)<<8
+		s.enable = data>>7&0b1 == 1
+		if !s.enable {
+			s.step = 0
+			s.accumulator = 0
+		}
+	}
+}
+
+func (s *vrc6Saw) clock(shift uint) {
+	if !s.enable {
+		return
+	}
+	if s.timer > 0 {
+		s.timer--
+		return
+	}
+	s.timer = s.period >> shift
+
+	s.step++
+	if s.step == 14 {
+		s.step = 0
+		s.accumulator = 0
+	} else if s.step%2 == 0 {
+		s.accumulator += s.rate
+	}
+}
+
+func (s *vrc6Saw) output() int {
+	if !s.enable {
+		return 0
+	}
+	return int(s.accumulator >> 3)
+}
+
+func (m *vrc6) Mirroring() [4]byte {
+	return mirrorPages(m.mirroring)
+}
@@ -0,0 +1,153 @@
+package casette
+
+/*
+   VRC7 (mapper 85)
+   |---------------+------+--------------------------------------|
+   | Address range | Bank | Notes                                |
+   |---------------+------+--------------------------------------|
+   | $6000-$7FFF   |      | PRG RAM, enabled by $E000 bit 7      |
+   | $8000-$9FFF   | 8 KB | $8000                                |
+   | $A000-$BFFF   | 8 KB | $8010                                |
+   | $C000-$DFFF   | 8 KB | $9000                                |
+   | $E000-$FFFF   | 8 KB | last bank                            |
+   |---------------+------+--------------------------------------|
+
+   Registers ($X010 is $X008 on VRC7b)
+   |-------------+-----------------------------------------------|
+   | Address     | Notes                                         |
+   |-------------+-----------------------------------------------|
+   | $9010       | audio register select                         |
+   | $9030       | audio register write                          |
+   | $A000-$D010 | CHR banks R0-R7                               |
+   | $E000       | PRG RAM enable, audio silence, mirroring      |
+   | $E010       | IRQ latch                                     |
+   | $F000       | IRQ control                                   |
+   | $F010       | IRQ acknowledge                               |
+   |-------------+-----------------------------------------------|
+
+   Submapper 1: VRC7b (A3), submapper 2: VRC7a (A4), 0: both
+*/
+type vrc7 struct {
+	baseMapper
+	select_mask uint16
+
+	prg_banks [3]byte
+	chr_banks [8]byte
+	control   byte
+	mirroring byte
+
+	irq vrcIrq
+
+	audio_reg byte
+	fm        *opll
+}
+
+func newVrc7(submapper int) *vrc7 {
+	m := new(vrc7)
+	switch submapper {
+	case 1:
+		m.select_mask = 0x08
+	case 2:
+		m.select_mask = 0x10
+	default:
+		m.select_mask = 0x18
+	}
+	m.mirroring = Cart.Mirroring
+	m.fm = newOpll()
+	return m
+}
+
+func (m *vrc7) CpuRead(addr uint16) byte {
+	switch {
+	case addr >= 0xE000:
+		return readBank(Prg_rom, -1, 0x2000, addr)
+	case addr >= 0x8000:
+		slot := int(addr-0x8000) / 0x2000
+		return readBank(Prg_rom, int(m.prg_banks[slot]&0x3F), 0x2000, addr)
+	case addr >= 0x6000:
+		if m.control>>7&0b1 == 1 {
+			return Prg_ram[addr-0x6000]
+		}
+	}
+	return byte(addr >> 8)
+}
+
+func (m *vrc7) CpuWrite(addr uint16, data byte) {
+	if addr < 0x8000 {
+		if addr >= 0x6000 && m.control>>7&0b1 == 1 {
+			Prg_ram[addr-0x6000] = data
+		}
+		return
+	}
+
+	odd := addr&m.select_mask != 0
+	switch addr & 0xF000 {
+	case 0x8000:
+		if odd {
+			m.prg_banks[1] = data
+		} else {
+			m.prg_banks[0] = data
+		}
+	case 0x9000:
+		switch {
+		case addr&0x30 == 0x30:
+			m.fm.write(m.audio_reg, data)
+		case addr&0x10 != 0:
+			m.audio_reg = data
+		case !odd:
+			m.prg_banks[2] = data
+		}
+	case 0xA000, 0xB000, 0xC000, 0xD000:
+		bank := (addr - 0xA000) >> 12 * 2
+		if odd {
+			bank++
+		}
+		m.chr_banks[bank] = data
+	case 0xE000:
+		if odd {
+			m.irq.latch = data
+		} else {
+			m.control = data
+			m.mirroring = vrcMirroring(data)
+			if data>>6&0b1 == 1 {
+				m.fm.reset()
+			}
+		}
+	case 0xF000:
+		if odd {
+			m.irq.ack()
+		} else {
+			m.irq.writeControl(data)
+		}
+	}
+}
+
+func (m *vrc7) PpuRead(addr uint16) byte {
+	return readChr(int(m.chr_banks[addr/0x0400]), 0x0400, addr)
+}
+
+func (m *vrc7) PpuWrite(addr uint16, data byte) {
+	writeChr(int(m.chr_banks[addr/0x0400]), 0x0400, addr, data)
+}
+
+func (m *vrc7) Clock() {
+	m.irq.clock()
+	if m.control>>6&0b1 == 0 {
+		m.fm.clock()
+	}
+}
+
+func (m *vrc7) Irq() bool {
+	return m.irq.flag
+}
+
+func (m *vrc7) Output() float32 {
+	if m.control>>6&0b1 == 1 {
+		return 0
+	}
+	return m.fm.output()
+}
+
+func (m *vrc7) Mirroring() [4]byte {
+	return mirrorPages(m.mirroring)
+}
@@ -0,0 +1,232 @@
+package casette
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestVrc(mapper int, submapper int, prg_ram int) *vrc {
+	Cart = &Cartridge{Mapper: mapper, Submapper: submapper, PrgRamSize: prg_ram}
+	Prg_rom = filledBanks(16, 0x2000)
+	Prg_ram = make([]byte, 0x2000)
+	Chr_rom = filledBanks(256, 0x0400)
+	Chr_ram = nil
+	return newVrc(mapper, submapper)
+}
+
+// CPU address lines of VRC A0 and A1 on each board
+func TestVrcWiring(t *testing.T) {
+	tests := []struct {
+		mapper    int
+		submapper int
+		a0, a1    uint16
+		// lines of the other wiring of the mapper, ignored by the board
+		other uint16
+	}{
+		{21, 1, 0x02, 0x04, 0x40 | 0x80},
+		{21, 2, 0x40, 0x80, 0x02 | 0x04},
+		{22, 0, 0x02, 0x01, 0},
+		{23, 1, 0x01, 0x02, 0x04 | 0x08},
+		{23, 2, 0x04, 0x08, 0x01 | 0x02},
+		{23, 3, 0x01, 0x02, 0x04 | 0x08},
+		{25, 1, 0x02, 0x01, 0x08 | 0x04},
+		{25, 2, 0x08, 0x04, 0x02 | 0x01},
+		{25, 3, 0x02, 0x01, 0x08 | 0x04},
+	}
+	for _, tt := range tests {
+		m := newTestVrc(tt.mapper, tt.submapper, 0x2000)
+		addrs := []uint16{0xB000, 0xB000 | tt.a0, 0xB000 | tt.a1, 0xB000 | tt.a0 | tt.a1}
+		for reg, addr := range addrs {
+			if got := m.register(addr | tt.other); got != 0xB000|uint16(reg) {
+				t.Errorf("mapper %d.%d: $%04X is register $%04X, want $%04X", tt.mapper, tt.submapper, addr|tt.other, got, 0xB000|reg)
+			}
+		}
+
+		// $B000-$B003: CHR bank 0 low/high, bank 1 low/high
+		for reg, addr := range addrs {
+			m.CpuWrite(addr, byte(reg+1))
+		}
+		want := [2]uint16{0x21, 0x43}
+		// VRC2a ignores the lowest bit
+		if tt.mapper == 22 {
+			want = [2]uint16{0x21 >> 1, 0x43 >> 1}
+		}
+		if got := [2]uint16{uint16(m.chrBank(0x0000)), uint16(m.chrBank(0x0400))}; got != want {
+			t.Errorf("mapper %d.%d: CHR banks $%02X $%02X, want $%02X $%02X", tt.mapper, tt.submapper, got[0], got[1], want[0], want[1])
+		}
+	}
+
+	// Submapper 0 combines both wirings, unknown submappers too
+	for _, submapper := range []int{0, 4, 15} {
+		m := newTestVrc(21, submapper, 0x2000)
+		for _, addr := range []uint16{0x8002, 0x8040} {
+			if got := m.register(addr); got != 0x8001 {
+				t.Errorf("mapper 21.%d: $%04X is register $%04X, want $8001", submapper, addr, got)
+			}
+		}
+		warned := len(Cart.Warnings) == 1 && strings.Contains(Cart.Warnings[0], "unknown submapper")
+		if warned != (submapper != 0) {
+			t.Errorf("mapper 21.%d: warnings %q", submapper, Cart.Warnings)
+		}
+	}
+}
+
+// VRC2 boards without PRG RAM have a 1 bit latch at $6000-$6FFF
+func TestVrc2Latch(t *testing.T) {
+	m := newTestVrc(23, 3, 0)
+	m.CpuWrite(0x6000, 0xFF)
+	if got := m.CpuRead(0x6000); got != 0x61 {
+		t.Errorf("latch $%02X, want $61", got)
+	}
+	if got := m.CpuRead(0x7000); got != 0x70 {
+		t.Errorf("$7000 $%02X, want open bus $70", got)
+	}
+
+	m = newTestVrc(23, 3, 0x2000)
+	m.CpuWrite(0x6000, 0x5A)
+	if got := m.CpuRead(0x6000); got != 0x5A {
+		t.Errorf("PRG RAM $%02X, want $5A", got)
+	}
+}
+
+func TestVrcIrq(t *testing.T) {
+	tests := []struct {
+		name    string
+		control byte
+		// CPU cycles until the IRQ of 3 counter clocks
+		want int
+	}{
+		// 341/3 CPU cycles per scanline: 114, 114 and 113 cycles
+		{"scanline", 0x02, 341},
+		{"cycle", 0x06, 3},
+	}
+	for _, tt := range tests {
+		var q vrcIrq
+		q.latch = 0xFD
+		q.writeControl(tt.control)
+		cycles := 0
+		for ; cycles < 1000 && !q.flag; cycles++ {
+			q.clock()
+		}
+		if cycles != tt.want {
+			t.Errorf("%s: IRQ after %d cycles, want %d", tt.name, cycles, tt.want)
+		}
+		if q.counter != 0xFD {
+			t.Errorf("%s: counter $%02X after the IRQ, want the latch $FD", tt.name, q.counter)
+		}
+
+		// Acknowledge copies the enable after acknowledgement bit
+		q.ack()
+		if q.flag || q.enable {
+			t.Errorf("%s: flag %t enable %t after the acknowledgement", tt.name, q.flag, q.enable)
+		}
+	}
+}
+
+// VRC4 PRG mode swaps $8000 and $C000
+func TestVrc4PrgMode(t *testing.T) {
+	m := newTestVrc(21, 1, 0x2000)
+	m.CpuWrite(0x8000, 3)
+	m.CpuWrite(0xA000, 5)
+	want := []byte{3, 5, 14, 15}
+	for i, w := range want {
+		if got := m.CpuRead(0x8000 + uint16(i)*0x2000); got != w {
+			t.Errorf("mode 0 $%04X: bank %d, want %d", 0x8000+i*0x2000, got, w)
+		}
+	}
+	m.CpuWrite(0x9004, 0x02)
+	want = []byte{14, 5, 3, 15}
+	for i, w := range want {
+		if got := m.CpuRead(0x8000 + uint16(i)*0x2000); got != w {
+			t.Errorf("mode 1 $%04X: bank %d, want %d", 0x8000+i*0x2000, got, w)
+		}
+	}
+}
+
+func TestVrc6Banking(t *testing.T) {
+	for _, mapper := range []int{24, 26} {
+		Cart = &Cartridge{Mapper: mapper}
+		Prg_rom = filledBanks(16, 0x2000)
+		Chr_rom = filledBanks(64, 0x0400)
+		Chr_ram = nil
+		m := newVrc6(mapper)
+		// Registers by the VRC6a address, A0 and A1 are swapped on VRC6b
+		write := func(addr uint16, data byte) {
+			if mapper == 26 {
+				addr = addr&0xFFFC | addr&0b01<<1 | addr&0b10>>1
+			}
+			m.CpuWrite(addr, data)
+		}
+
+		write(0x8000, 3)
+		write(0xC000, 5)
+		for i, w := range []byte{6, 7, 5, 15} {
+			if got := m.CpuRead(0x8000 + uint16(i)*0x2000); got != w {
+				t.Errorf("mapper %d $%04X: bank %d, want %d", mapper, 0x8000+i*0x2000, got, w)
+			}
+		}
+
+		for i := uint16(0); i < 8; i++ {
+			write(0xD000+i/4*0x1000+i%4, byte(10+i))
+		}
+		tests := []struct {
+			banking byte
+			want    [8]byte
+		}{
+			{0, [8]byte{10, 11, 12, 13, 14, 15, 16, 17}},
+			{1, [8]byte{20, 21, 22, 23, 24, 25, 26, 27}},
+			{2, [8]byte{10, 11, 12, 13, 28, 29, 30, 31}},
+		}
+		for _, tt := range tests {
+			write(0xB003, tt.banking)
+			var got [8]byte
+			for i := range got {
+				got[i] = m.PpuRead(uint16(i) * 0x0400)
+			}
+			if got != tt.want {
+				t.Errorf("mapper %d banking %d: CHR banks %v, want %v", mapper, tt.banking, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestVrc7Banking(t *testing.T) {
+	tests := []struct {
+		submapper int
+		odd       uint16
+	}{
+		{1, 0x08},
+		{2, 0x10},
+		{0, 0x08},
+		{0, 0x10},
+	}
+	for _, tt := range tests {
+		Cart = &Cartridge{Mapper: 85, Submapper: tt.submapper}
+		Prg_rom = filledBanks(16, 0x2000)
+		Chr_rom = filledBanks(64, 0x0400)
+		Chr_ram = nil
+		m := newVrc7(tt.submapper)
+
+		m.CpuWrite(0x8000, 2)
+		m.CpuWrite(0x8000|tt.odd, 3)
+		m.CpuWrite(0x9000, 4)
+		for i, w := range []byte{2, 3, 4, 15} {
+			if got := m.CpuRead(0x8000 + uint16(i)*0x2000); got != w {
+				t.Errorf("submapper %d $%02X: $%04X bank %d, want %d", tt.submapper, tt.odd, 0x8000+i*0x2000, got, w)
+			}
+		}
+
+		for i := uint16(0); i < 8; i++ {
+			addr := 0xA000 + i/2*0x1000
+			if i%2 == 1 {
+				addr |= tt.odd
+			}
+			m.CpuWrite(addr, byte(20+i))
+		}
+		for i := uint16(0); i < 8; i++ {
+			if got := m.PpuRead(i * 0x0400); got != byte(20+i) {
+				t.Errorf("submapper %d $%02X: CHR slot %d bank %d, want %d", tt.submapper, tt.odd, i, got, 20+i)
+			}
+		}
+	}
+}
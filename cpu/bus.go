@@ -0,0 +1,38 @@
+package cpu
+
+import "github.com/siva0410/emu/casette"
+
+// Read CPU memory, cartridge space goes through the mapper
+func readMem(addr uint16) byte {
+	switch {
+	case addr < 0x2000:
+		return CPU_MEM[addr&0x07FF]
+	case addr < 0x4000:
+		addr = 0x2000 + addr&0x0007
+		CPU_MEM_READ[addr] = true
+		return CPU_MEM[addr]
+	case addr >= 0x4020:
+		return casette.Cart_mapper.CpuRead(addr)
+	}
+	return CPU_MEM[addr]
+}
+
+// Write CPU memory, cartridge space goes through the mapper
+func writeMem(addr uint16, data byte) {
+	switch {
+	case addr < 0x2000:
+		addr &= 0x07FF
+	case addr < 0x4000:
+		// the cartridge also sees writes to the PPU registers
+		addr = 0x2000 + addr&0x0007
+		casette.Cart_mapper.CpuWrite(addr, data)
+	case addr == 0x4014:
+		oam_dma_page = data
+		oam_dma_pending = true
+	case addr >= 0x4020:
+		casette.Cart_mapper.CpuWrite(addr, data)
+		return
+	}
+	CPU_MEM[addr] = data
+	CPU_MEM_CHK[addr] = true
+}
@@ -91,7 +91,7 @@ func getOperand(mode string) uint16 {
 		reg.PC++
 		tmp = tmp + uint16(fetchPC())<<0x8
 		reg.PC++
-		operand = (uint16(CPU_MEM[tmp]) + uint16(fetchPC())<<0x8)
+		operand = (uint16(readMem(tmp)) + uint16(fetchPC())<<0x8)
 		reg.PC++
 
 	default:
@@ -108,33 +108,33 @@ func execOpecode(opecode byte) int {
 		res = uint(operand)
 		setZeroFlag(res)
 		setNegativeFlag(res)
-		reg.A = CPU_MEM[operand]
+		reg.A = readMem(operand)
 		// check ppu_addr register
 
 	case "LDX":
 		res = uint(operand)
 		setZeroFlag(res)
 		setNegativeFlag(res)
-		reg.X = CPU_MEM[operand]
+		reg.X = readMem(operand)
 		// check ppu_addr register
 
 	case "LDY":
 		res = uint(operand)
 		setZeroFlag(res)
 		setNegativeFlag(res)
-		reg.Y = CPU_MEM[operand]
+		reg.Y = readMem(operand)
 		// check ppu_addr register
 
 	case "STA":
-		CPU_MEM[operand] = reg.A
+		writeMem(operand, reg.A)
 		// check ppu_addr register
 
 	case "STX":
-		CPU_MEM[operand] = reg.X
+		writeMem(operand, reg.X)
 		// check ppu_addr register
 
 	case "STY":
-		CPU_MEM[operand] = reg.Y
+		writeMem(operand, reg.Y)
 		// check ppu_addr register
 
 	case "TAX":
@@ -205,8 +205,8 @@ func execOpecode(opecode byte) int {
 		setNegativeFlag(res)
 
 	case "INC":
-		CPU_MEM[operand]++
-		res = uint(CPU_MEM[operand])
+		writeMem(operand, readMem(operand)+1)
+		res = uint(readMem(operand))
 		setZeroFlag(res)
 		setNegativeFlag(res)
 
@@ -223,8 +223,8 @@ func execOpecode(opecode byte) int {
 		setNegativeFlag(res)
 
 	case "DEC":
-		CPU_MEM[operand]--
-		res = uint(CPU_MEM[operand])
+		writeMem(operand, readMem(operand)-1)
+		res = uint(readMem(operand))
 		setZeroFlag(res)
 		setNegativeFlag(res)
 
@@ -234,18 +234,26 @@ func execOpecode(opecode byte) int {
 	// case "CLD":
 	// case "SEC":
 	case "SEI":
-		setStatus("interrupt_disable", false)
+		setStatus("interrupt_disable", true)
 	// case "SED":
 	// case "NOP":
 	case "BRK":
 		if !getStatus("interrupt_disable") {
 			// interrupt handler
 		}
-	// case "JSR":
+	case "JSR":
+		// push the address of the last byte of the instruction
+		push(byte((reg.PC - 1) >> 8))
+		push(byte(reg.PC - 1))
+		reg.PC = operand
 	case "JMP":
 		reg.PC = operand
-	// case "RTI":
-	// case "RTS":
+	case "RTI":
+		// B and bit 5 are not stored in the status register
+		reg.P = pull()&0b11001111 | reg.P&0b00110000
+		reg.PC = uint16(pull()) + uint16(pull())<<0x8
+	case "RTS":
+		reg.PC = uint16(pull()) + uint16(pull())<<0x8 + 1
 	// case "BPL":
 	// case "BMI":
 	// case "BVC":
@@ -272,16 +280,11 @@ func execOpecode(opecode byte) int {
 	// fmt.Printf("ppudata:%x\t\n", Ppu_reg.Ppudata)
 	// fmt.Printf("MEM ppuaddr:%x\n\n", PPU_PTR)
 
-	CPU_MEM_CHK[operand] = true
-
 	return inst_arr[opecode].cycle
 }
 
 // Init CPU
 func InitCpu() {
-	// Read Rom
-	copy(CPU_MEM[PRG_ROM_ADDR:], casette.Prg_rom[:])
-
 	// init register
 	reg = initRegister()
 	reg = resetRegister()
@@ -291,11 +294,103 @@ func InitCpu() {
 	setInstList()
 }
 
-// Execute loaded ROM
-func ExecCpu(cycle *int) {
+// Execute an instruction and add its PPU dots to cycle, it returns the CPU
+// cycles of the instruction including DMA and interrupts
+func ExecCpu(cycle *int) int {
 	// Execute ROM
 	opecode := fetchPC()
 	reg.PC++
 
-	*cycle += execOpecode(opecode) * 3
+	cpu_cycle := execOpecode(opecode)
+	total_cycles += uint64(cpu_cycle)
+
+	// OAM DMA halts the CPU after the write to $4014
+	if oam_dma_pending {
+		dma_cycle := oamDma()
+		total_cycles += uint64(dma_cycle)
+		cpu_cycle += dma_cycle
+	}
+
+	// Check NMI from PPU
+	if Nmi_pending {
+		Nmi_pending = false
+		nmi_cycle := interrupt(NMI_VECTOR)
+		total_cycles += uint64(nmi_cycle)
+		cpu_cycle += nmi_cycle
+	}
+
+	// Check IRQ from cartridge
+	if casette.Cart_mapper.Irq() && !getStatus("interrupt_disable") {
+		irq_cycle := interrupt(IRQ_VECTOR)
+		total_cycles += uint64(irq_cycle)
+		cpu_cycle += irq_cycle
+	}
+
+	// Clock cartridge for the instruction, DMA and interrupt cycles
+	for i := 0; i < cpu_cycle; i++ {
+		casette.Cart_mapper.Clock()
+	}
+
+	// PPU dots, 3.2 per CPU cycle on PAL
+	dots := cpu_cycle*casette.Console.DotsNum + dot_remainder
+	*cycle += dots / casette.Console.DotsDen
+	dot_remainder = dots % casette.Console.DotsDen
+	return cpu_cycle
+}
+
+// NMI signaled by the PPU, taken after the current instruction
+var Nmi_pending bool
+
+// PPU dots not added to the cycle yet
+var dot_remainder int
+
+/*
+   |-----------+------------+------------|
+   | Interrupt | Lower Addr | Upper Addr |
+   |-----------+------------+------------|
+   | NMI       |     0xFFFA |     0xFFFB |
+   | IRQ/BRK   |     0xFFFE |     0xFFFF |
+   |-----------+------------+------------|
+*/
+const (
+	NMI_VECTOR uint16 = 0xFFFA
+	IRQ_VECTOR uint16 = 0xFFFE
+)
+
+// Push PC and status to the stack and jump to the vector
+func interrupt(vector uint16) int {
+	push(byte(reg.PC >> 8))
+	push(byte(reg.PC))
+	push(reg.P&0b11101111 | 0b00100000)
+	setStatus("interrupt_disable", true)
+	reg.PC = uint16(readMem(vector)) + uint16(readMem(vector+1))<<0x8
+
+	return 7
+}
+
+// Push data to the stack page $0100-$01FF
+func push(data byte) {
+	writeMem(0x0100+uint16(reg.S), data)
+	reg.S--
+}
+
+// Pull data from the stack page $0100-$01FF
+func pull() byte {
+	reg.S++
+	return readMem(0x0100 + uint16(reg.S))
+}
+
+// Program counter of the next instruction
+func PC() uint16 {
+	return reg.PC
+}
+
+// Call a subroutine from the current PC as JSR does, it returns to the
+// current PC with RTS. Used by the NSF player to call init and play.
+func CallRoutine(addr uint16, a byte, x byte) {
+	push(byte((reg.PC - 1) >> 8))
+	push(byte(reg.PC - 1))
+	reg.PC = addr
+	reg.A = a
+	reg.X = x
 }
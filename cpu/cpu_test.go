@@ -0,0 +1,271 @@
+package cpu
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/siva0410/emu/casette"
+)
+
+/*
+   NSF loaded at $8000
+   init $8000: STA $0200, STX $0201, STA $5205, STX $5206, LDA $5205,
+               STA $0203, RTS
+   play $8013: INC $0202, RTS
+*/
+func loadNsf(t *testing.T) {
+	t.Helper()
+	header := make([]byte, 0x80)
+	copy(header, "NESM\x1a\x01\x04\x01\x00\x80\x00\x80\x13\x80")
+	code := []byte{
+		0x8D, 0x00, 0x02,
+		0x8E, 0x01, 0x02,
+		0x8D, 0x05, 0x52,
+		0x8E, 0x06, 0x52,
+		0xAD, 0x05, 0x52,
+		0x8D, 0x03, 0x02,
+		0x60,
+		0xEE, 0x02, 0x02,
+		0x60,
+	}
+	path := filepath.Join(t.TempDir(), "music.nsf")
+	if err := os.WriteFile(path, append(header, code...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := casette.SetRom(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Run until the CPU is back in the NSF driver loop
+func runToDriver(t *testing.T) {
+	t.Helper()
+	cycle := new(int)
+	for i := 0; i < 100; i++ {
+		ExecCpu(cycle)
+		if reg.PC == casette.NSF_DRIVER_ADDR {
+			return
+		}
+	}
+	t.Fatalf("PC $%04X did not return to the driver", reg.PC)
+}
+
+func TestCallRoutine(t *testing.T) {
+	loadNsf(t)
+	InitCpu()
+	if reg.PC != casette.NSF_DRIVER_ADDR {
+		t.Fatalf("reset PC $%04X, want the driver $%04X", reg.PC, casette.NSF_DRIVER_ADDR)
+	}
+	s := reg.S
+
+	// Init gets the song in A and PAL in X
+	CallRoutine(casette.Nsf.Init, 3, 1)
+	runToDriver(t)
+	if CPU_MEM[0x0200] != 3 || CPU_MEM[0x0201] != 1 {
+		t.Errorf("init stored A=%d X=%d, want 3 and 1", CPU_MEM[0x0200], CPU_MEM[0x0201])
+	}
+	// The MMC5 multiplier works without the MMC5 chip bit
+	if CPU_MEM[0x0203] != 3 {
+		t.Errorf("init read %d from the multiplier, want 3", CPU_MEM[0x0203])
+	}
+
+	// The driver loops on itself until play is called
+	cycle := new(int)
+	for i := 0; i < 3; i++ {
+		ExecCpu(cycle)
+		if reg.PC != casette.NSF_DRIVER_ADDR {
+			t.Fatalf("driver loop left to $%04X", reg.PC)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		CallRoutine(casette.Nsf.Play, 0, 0)
+		runToDriver(t)
+	}
+	if CPU_MEM[0x0202] != 2 {
+		t.Errorf("play was called %d times, want 2", CPU_MEM[0x0202])
+	}
+	if reg.S != s {
+		t.Errorf("stack pointer $%02X after the calls, want $%02X", reg.S, s)
+	}
+}
+
+func TestExecCpuCycles(t *testing.T) {
+	loadNsf(t)
+	defer casette.SetRegion(casette.REGION_NTSC)
+
+	tests := []struct {
+		region byte
+		dots   int
+	}{
+		// JMP takes 3 CPU cycles, 9.6 dots on PAL
+		{casette.REGION_NTSC, 5 * 9},
+		{casette.REGION_PAL, 48},
+		{casette.REGION_DENDY, 5 * 9},
+	}
+	for _, tt := range tests {
+		casette.SetRegion(tt.region)
+		InitCpu()
+		dot_remainder = 0
+		cycle := new(int)
+		cpu_cycles := 0
+		for i := 0; i < 5; i++ {
+			cpu_cycles += ExecCpu(cycle)
+		}
+		if cpu_cycles != 15 || *cycle != tt.dots {
+			t.Errorf("region %d: %d CPU cycles and %d dots, want 15 and %d", tt.region, cpu_cycles, *cycle, tt.dots)
+		}
+	}
+}
+
+// Mapper counting the CPU cycles it is clocked
+type clockCounter struct {
+	casette.Mapper
+	clocks int
+}
+
+func (c *clockCounter) Clock() {
+	c.clocks++
+	c.Mapper.Clock()
+}
+
+// The mapper and total_cycles see the DMA and interrupt cycles too
+func TestExecCpuClock(t *testing.T) {
+	tests := []struct {
+		name string
+		nmi  bool
+		dma  bool
+		want int
+	}{
+		{"instruction", false, false, 3},
+		{"nmi", true, false, 3 + 7},
+		// the DMA after JMP starts on an odd cycle
+		{"dma", false, true, 3 + 514},
+		{"dma and nmi", true, true, 3 + 514 + 7},
+	}
+	for _, tt := range tests {
+		loadNsf(t)
+		InitCpu()
+		counter := &clockCounter{Mapper: casette.Cart_mapper}
+		casette.Cart_mapper = counter
+		total_cycles = 0
+		Nmi_pending = tt.nmi
+		oam_dma_pending = tt.dma
+
+		cycles := ExecCpu(new(int))
+		if cycles != tt.want || counter.clocks != tt.want || total_cycles != uint64(tt.want) {
+			t.Errorf("%s: %d cycles, %d mapper clocks, total %d, want %d", tt.name, cycles, counter.clocks, total_cycles, tt.want)
+		}
+	}
+}
+
+// Mapper holding its IRQ line
+type irqMapper struct {
+	casette.Mapper
+}
+
+func (m irqMapper) Irq() bool {
+	return true
+}
+
+// Run the code from $0300 for one instruction, returns the CPU cycles
+func execAt(t *testing.T, code ...byte) int {
+	t.Helper()
+	copy(CPU_MEM[0x0300:], code)
+	reg.PC = 0x0300
+	return ExecCpu(new(int))
+}
+
+// SEI sets the I flag, so the pending IRQ is not taken after it
+func TestSeiMasksIrq(t *testing.T) {
+	tests := []struct {
+		name string
+		code byte
+		irq  bool
+	}{
+		{"SEI", 0x78, false},
+		{"NOP", 0xEA, true},
+	}
+	for _, tt := range tests {
+		loadNsf(t)
+		InitCpu()
+		casette.Cart_mapper = irqMapper{casette.Cart_mapper}
+		setStatus("interrupt_disable", false)
+		s := reg.S
+
+		execAt(t, tt.code)
+		if irq := reg.PC != 0x0301; irq != tt.irq {
+			t.Errorf("%s: IRQ taken %t, want %t", tt.name, irq, tt.irq)
+		}
+		if tt.irq && reg.S != s-3 {
+			t.Errorf("%s: stack pointer $%02X after the IRQ, want $%02X", tt.name, reg.S, s-3)
+		}
+		if !getStatus("interrupt_disable") {
+			t.Errorf("%s: I flag is clear", tt.name)
+		}
+	}
+}
+
+// Only writes mark the PPU registers, reads and immediates don't
+func TestMemChk(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		addr uint16
+		want bool
+	}{
+		{"LDA $2002", []byte{0xAD, 0x02, 0x20}, 0x2002, false},
+		{"LDA $2007", []byte{0xAD, 0x07, 0x20}, 0x2007, false},
+		{"LDA #$06", []byte{0xA9, 0x06}, 0x0006, false},
+		{"STA $2006", []byte{0x8D, 0x06, 0x20}, 0x2006, true},
+		{"STA $3FFE", []byte{0x8D, 0xFE, 0x3F}, 0x2006, true},
+	}
+	for _, tt := range tests {
+		loadNsf(t)
+		InitCpu()
+		CPU_MEM_CHK = [0x10000]bool{}
+		execAt(t, tt.code...)
+		if CPU_MEM_CHK[tt.addr] != tt.want {
+			t.Errorf("%s: $%04X marked %t, want %t", tt.name, tt.addr, CPU_MEM_CHK[tt.addr], tt.want)
+		}
+	}
+}
+
+// STA $4014 copies the page to OAMDATA and halts the CPU
+func TestOamDma(t *testing.T) {
+	tests := []struct {
+		name  string
+		start uint64
+		want  int
+	}{
+		// STA takes 4 cycles, the DMA starts after the write cycle
+		{"even", 0, 4 + 513},
+		{"odd", 1, 4 + 514},
+	}
+	defer func() { OamWrite = nil }()
+	for _, tt := range tests {
+		loadNsf(t)
+		InitCpu()
+		for i := 0; i < 0x100; i++ {
+			CPU_MEM[0x0200+i] = byte(i ^ 0x5A)
+		}
+		var oam []byte
+		OamWrite = func(data byte) {
+			oam = append(oam, data)
+		}
+		total_cycles = tt.start
+		reg.A = 0x02
+
+		if cycles := execAt(t, 0x8D, 0x14, 0x40); cycles != tt.want {
+			t.Errorf("%s: %d cycles, want %d", tt.name, cycles, tt.want)
+		}
+		if !bytes.Equal(oam, CPU_MEM[0x0200:0x0300]) {
+			t.Errorf("%s: OAMDATA got %d bytes, not page $02", tt.name, len(oam))
+		}
+		if oam_dma_pending {
+			t.Errorf("%s: DMA still pending", tt.name)
+		}
+	}
+}
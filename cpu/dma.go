@@ -0,0 +1,39 @@
+package cpu
+
+// OAMDATA port of the PPU, set by the PPU on init
+var OamWrite func(data byte)
+
+var oam_dma_page byte
+var oam_dma_pending bool
+
+// CPU cycles since power on, DMA aligns to even (get) cycles
+var total_cycles uint64
+
+/*
+   OAM DMA ($4014 write)
+   |--------+--------------------------------------------------|
+   | Cycles | Notes                                            |
+   |--------+--------------------------------------------------|
+   | 1      | halt, wait for the write cycle to finish         |
+   | +1     | alignment when the DMA starts on an odd cycle    |
+   | 512    | 256 x (read $XX00+i, write OAMDATA)              |
+   |--------+--------------------------------------------------|
+   DMC DMA is not stolen from these cycles since there is no APU yet.
+*/
+func oamDma() int {
+	oam_dma_pending = false
+
+	stall := 513
+	if total_cycles&0b1 == 1 {
+		stall++
+	}
+
+	base := uint16(oam_dma_page) << 8
+	for i := uint16(0); i < 0x100; i++ {
+		data := readMem(base + i)
+		if OamWrite != nil {
+			OamWrite(data)
+		}
+	}
+	return stall
+}
@@ -33,9 +33,9 @@ func initRegister() *Register {
    |-----------+------------+------------|
 */
 func resetRegister() *Register {
-	lower_addr := 0xFFFC
-	upper_addr := 0xFFFD
-	reset_point := uint16(CPU_MEM[lower_addr]) + (uint16(CPU_MEM[upper_addr]) << 0x8)
+	var lower_addr uint16 = 0xFFFC
+	var upper_addr uint16 = 0xFFFD
+	reset_point := uint16(readMem(lower_addr)) + (uint16(readMem(upper_addr)) << 0x8)
 	reset_reg := new(Register)
 	reset_reg.A = 0x00
 	reset_reg.X = 0x00
@@ -159,5 +159,5 @@ func setNegativeFlag(num uint) {
 
 // Fetch inst by PC
 func fetchPC() byte {
-	return CPU_MEM[reg.PC]
+	return readMem(reg.PC)
 }
@@ -24,4 +24,7 @@ package cpu
 */
 var CPU_MEM [0x10000]byte
 var CPU_MEM_CHK [0x10000]bool
+
+// PPU registers read by the CPU, for read side effects handled by the PPU
+var CPU_MEM_READ [0x2008]bool
 var PRG_ROM_ADDR uint16 = 0x8000
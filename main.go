@@ -1,8 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"image/png"
+	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/go-gl/gl/v4.6-core/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
@@ -13,53 +18,86 @@ import (
 	"github.com/siva0410/emu/window"
 )
 
-func printMem() {
-	// Print PPU_MEM
-	fmt.Printf("ADDR| ")
-	for i := 0; i < 0x20; i++ {
-		if i == 0x10 {
-			fmt.Printf("| ")
-		}
-		fmt.Printf("%02x ", i)
+func printCart() {
+	cart := casette.Cart
+	fmt.Printf("CRC32:      %08X\n", cart.Crc32)
+	fmt.Printf("SHA-1:      %s\n", cart.Sha1)
+	if cart.Known {
+		fmt.Printf("Game:       %s\n", cart.Name)
+	} else {
+		fmt.Printf("Game:       %s (not in database)\n", cart.Name)
+	}
+	if cart.Board != "" {
+		fmt.Printf("Board:      %s\n", cart.Board)
+	}
+	fmt.Printf("Mapper:     %d.%d\n", cart.Mapper, cart.Submapper)
+	fmt.Printf("Mirroring:  %d\n", cart.Mirroring)
+	fmt.Printf("Region:     %d (running as %d)\n", cart.Region, casette.Region)
+	fmt.Printf("PRG RAM:    %d bytes\n", cart.PrgRamSize)
+	fmt.Printf("CHR RAM:    %d bytes\n", cart.ChrRamSize)
+	fmt.Printf("Battery:    %t\n", cart.Battery)
+	if len(cart.Corrections) == 0 {
+		fmt.Printf("Header:     ok\n")
 	}
-	fmt.Printf("\n")
+	for _, c := range cart.Corrections {
+		fmt.Printf("Corrected:  %s\n", c)
+	}
+	for _, w := range cart.Warnings {
+		fmt.Printf("Warning:    %s\n", w)
+	}
+}
 
-	fmt.Printf("----+-")
-	for i := 0; i < 0x20; i++ {
-		if i == 0x10 {
-			fmt.Printf("+-")
-		}
-		fmt.Printf("---")
-	}
-
-	for i, m := range ppu.PPU_MEM {
-		if i == 0x1000 || i == 0x2000 || i == 0x23c0 || i == 0x2400 || i == 0x27c0 || i == 0x2800 || i == 0x2bc0 || i == 0x2c00 || i == 0x2fc0 || i == 0x3000 || i == 0x3f00 || i == 0x3f20 {
-			fmt.Printf("\n----+-")
-			for i := 0; i < 0x20; i++ {
-				if i == 0x10 {
-					fmt.Printf("+-")
-				}
-				fmt.Printf("---")
-			}
-		}
-		if i%0x20 == 0 {
-			fmt.Printf("\n")
-			fmt.Printf("%04x| ", i)
-		}
-		if i%0x20 != 0 && i%0x10 == 0 {
-			fmt.Printf("| ")
-		}
+// Print the status message of a hotkey, if any
+func printStatus(status string) {
+	if status != "" {
+		fmt.Println(status)
+	}
+}
 
-		if ppu.PPU_MEM_CHK[i] {
-			fmt.Printf("\x1b[31m")
-		}
-		fmt.Printf("%02x ", m)
-		if ppu.PPU_MEM_CHK[i] {
-			fmt.Printf("\x1b[0m")
-		}
+/*
+   Hotkeys
+   |-------+------------------------------------------|
+   | Key   | Notes                                    |
+   |-------+------------------------------------------|
+   | F5    | FDS: eject and insert the next disk side |
+   | F6    | FDS: eject or insert the disk            |
+   | Left  | NSF: previous track                      |
+   | Right | NSF: next track                          |
+   | F12   | save a screenshot as PNG                 |
+   |-------+------------------------------------------|
+*/
+func keyCallback(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	if action != glfw.Press {
+		return
+	}
+	switch key {
+	case glfw.KeyF5:
+		printStatus(casette.FdsSwitchSide())
+	case glfw.KeyF6:
+		printStatus(casette.FdsEject())
+	case glfw.KeyLeft:
+		changeNsfSong(-1)
+	case glfw.KeyRight:
+		changeNsfSong(1)
+	case glfw.KeyF12:
+		saveScreenshot()
+	}
+}
 
+// Save the last frame as a PNG file
+func saveScreenshot() {
+	path := time.Now().Format("screenshot-20060102-150405.png")
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Println("Cannot save screenshot:", err)
+		return
+	}
+	defer f.Close()
+	if err := png.Encode(f, ppu.Frame); err != nil {
+		fmt.Println("Cannot save screenshot:", err)
+		return
 	}
-	fmt.Printf("\n")
+	fmt.Println("Saved", path)
 }
 
 func RunNes() {
@@ -68,6 +106,7 @@ func RunNes() {
 	screen := window.InitGlfw()
 	defer glfw.Terminate()
 	program := window.InitOpenGL()
+	screen.SetKeyCallback(keyCallback)
 
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 	gl.UseProgram(program)
@@ -75,28 +114,122 @@ func RunNes() {
 	var cycle *int
 	cycle = new(int)
 
+	save_ticker := time.NewTicker(SAVE_INTERVAL)
+	defer save_ticker.Stop()
+
 	for !screen.ShouldClose() {
 		// Exec CPU and PPU
 		// PPU clock = 3*CPU clock
-		fmt.Printf("#cycle: %d\n", *cycle)
 		cpu.ExecCpu(cycle)
-		ppu.ExecPpu(cycle, screen)
+		if ppu.ExecPpu(cycle) {
+			window.Draw(ppu.Frame)
+			glfw.PollEvents()
+			screen.SwapBuffers()
+		}
+
+		// Flush battery-backed RAM
+		select {
+		case <-save_ticker.C:
+			flushSave()
+		default:
+		}
+	}
+}
+
+// Interval to flush battery-backed RAM to the save file
+const SAVE_INTERVAL = 5 * time.Second
+
+func flushSave() {
+	if err := casette.FlushSave(); err != nil {
+		fmt.Println("Cannot write save file:", err)
+	}
+}
+
+// Select the region by flag, auto uses the ROM header and game database
+func setRegion(name string) {
+	region := casette.Cart.Region
+	switch strings.ToLower(name) {
+	case "ntsc":
+		region = casette.REGION_NTSC
+	case "pal":
+		region = casette.REGION_PAL
+	case "dendy":
+		region = casette.REGION_DENDY
+	case "auto":
+	default:
+		fmt.Printf("Unknown region %s, using auto\n", name)
+	}
+	casette.SetRegion(region)
+}
+
+// Select the palette by flag: built-in table, generated NTSC palette or .pal file
+func setPalette(name string, params ppu.NtscParams) {
+	switch strings.ToLower(name) {
+	case "":
+	case "ntsc":
+		ppu.GenerateNtscPalette(params)
+	default:
+		if err := ppu.LoadPalette(name); err != nil {
+			fmt.Println("Cannot load palette:", err)
+		}
 	}
 }
 
 func main() {
+	save_dir := flag.String("savedir", "", "directory of save files (default: next to the ROM)")
+	game_db := flag.String("gamedb", "", "additional game database file")
+	bios := flag.String("bios", "", "FDS BIOS (default: disksys.rom next to the disk image or in the current directory)")
+	entry := flag.String("entry", "", "file to load from a .zip/.gz/.tar archive (default: first ROM)")
+	patches := flag.String("patch", "", "comma separated IPS/UPS/BPS patches (default: same name as the ROM)")
+	region := flag.String("region", "auto", "console region: auto, ntsc, pal or dendy")
+	info := flag.Bool("info", false, "print the detected cartridge information and exit")
+	palette := flag.String("palette", "", "palette: ntsc (generated) or a .pal file (default: built-in table)")
+	ntsc := ppu.DefaultNtscParams
+	flag.Float64Var(&ntsc.Hue, "hue", ntsc.Hue, "NTSC palette hue shift in degrees")
+	flag.Float64Var(&ntsc.Saturation, "saturation", ntsc.Saturation, "NTSC palette saturation")
+	flag.Float64Var(&ntsc.Contrast, "contrast", ntsc.Contrast, "NTSC palette contrast")
+	flag.Float64Var(&ntsc.Brightness, "brightness", ntsc.Brightness, "NTSC palette brightness")
+	flag.Float64Var(&ntsc.Gamma, "gamma", ntsc.Gamma, "NTSC palette display gamma")
+	flag.Parse()
+
 	// Read ROM
 	path := "./ROM/helloworld/helloworld.nes"
 	// path := "./ROM/nestest.nes"
-	casette.SetRom(path)
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+	casette.Save_dir = *save_dir
+	casette.Archive_entry = *entry
+	casette.Bios_path = *bios
+	if *patches != "" {
+		casette.Patches = strings.Split(*patches, ",")
+	}
+	if *game_db != "" {
+		if err := casette.LoadGameDb(*game_db); err != nil {
+			fmt.Println("Cannot load game database:", err)
+		}
+	}
+	if err := casette.SetRom(path); err != nil {
+		fmt.Println("Cannot load ROM:", err)
+		os.Exit(1)
+	}
+	setRegion(*region)
+	if *info {
+		printCart()
+		return
+	}
 
 	// Init CPU and PPU
 	cpu.InitCpu()
 	ppu.InitPpu()
+	setPalette(*palette, ntsc)
 
 	// Create window
+	if casette.Nsf != nil {
+		nsf_song = casette.Nsf.Start
+		RunNsf()
+		return
+	}
 	RunNes()
-
-	printMem()
-	fmt.Println(ppu.Palettes)
+	flushSave()
 }
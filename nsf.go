@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/go-gl/gl/v4.6-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+
+	"github.com/siva0410/emu/casette"
+	"github.com/siva0410/emu/cpu"
+	"github.com/siva0410/emu/ppu"
+	"github.com/siva0410/emu/window"
+)
+
+// Song selected with the track controls, from 0
+var nsf_song int
+
+// Play NSF songs, the CPU runs init once and play at the header rate
+func RunNsf() {
+	runtime.LockOSThread()
+
+	screen := window.InitGlfw()
+	defer glfw.Terminate()
+	program := window.InitOpenGL()
+	screen.SetKeyCallback(keyCallback)
+
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	gl.UseProgram(program)
+
+	nsf := casette.Nsf
+	clock, speed := casette.Console.CpuClock, nsf.NtscSpeed
+	if casette.Region == casette.REGION_PAL {
+		speed = nsf.PalSpeed
+	}
+	play_period := clock * speed / 1000000
+	frame_period := clock / 60
+
+	// PPU dots, the PPU is not run by the player
+	var cycle *int
+	cycle = new(int)
+
+	song := -1
+	var cpu_cycles, next_play, next_frame int
+	var start time.Time
+	for !screen.ShouldClose() {
+		if song != nsf_song {
+			song = nsf_song
+			startNsfSong(song)
+			cpu_cycles, next_play, next_frame = 0, 0, 0
+			start = time.Now()
+		}
+
+		// Call play when the CPU is waiting in the driver loop
+		if cpu.PC() == casette.NSF_DRIVER_ADDR && cpu_cycles >= next_play {
+			cpu.CallRoutine(nsf.Play, 0, 0)
+			next_play += play_period
+		}
+
+		cpu_cycles += cpu.ExecCpu(cycle)
+
+		if cpu_cycles >= next_frame {
+			next_frame += frame_period
+			drawText(ppu.Frame, nsfText(song))
+			window.Draw(ppu.Frame)
+			glfw.PollEvents()
+			screen.SwapBuffers()
+
+			// Keep the play rate in real time
+			elapsed := time.Duration(cpu_cycles) * time.Second / time.Duration(clock)
+			time.Sleep(time.Until(start.Add(elapsed)))
+		}
+	}
+}
+
+// Clear RAM and call init with the song number in A and PAL in X
+func startNsfSong(song int) {
+	for i := 0; i < 0x0800; i++ {
+		cpu.CPU_MEM[i] = 0
+	}
+	casette.NsfReset()
+	cpu.InitCpu()
+
+	var pal byte
+	if casette.Region == casette.REGION_PAL {
+		pal = 1
+	}
+	cpu.CallRoutine(casette.Nsf.Init, byte(song), pal)
+}
+
+func nsfText(song int) []string {
+	nsf := casette.Nsf
+	lines := []string{
+		"NSF PLAYER",
+		"",
+		nsf.Name,
+		nsf.Artist,
+		nsf.Copyright,
+		"",
+		fmt.Sprintf("TRACK %d/%d", song+1, nsf.Songs),
+	}
+	if song < len(nsf.Labels) {
+		lines = append(lines, nsf.Labels[song])
+	}
+	return append(lines, "", "LEFT/RIGHT: TRACK")
+}
+
+// Select the previous or next song
+func changeNsfSong(delta int) {
+	if casette.Nsf == nil {
+		return
+	}
+	songs := casette.Nsf.Songs
+	nsf_song = (nsf_song + delta + songs) % songs
+}
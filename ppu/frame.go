@@ -0,0 +1,33 @@
+package ppu
+
+import "image"
+
+const (
+	SCREEN_WIDTH  = 256
+	SCREEN_HEIGHT = 240
+)
+
+/*
+   Framebuffer
+   |------+------------------------------------------|
+   | Bits | Pixels                                   |
+   |------+------------------------------------------|
+   | 0-5  | palette entry $00-$3F                    |
+   | 6-8  | emphasis, PPUMASK bits 5-7 in NTSC order |
+   |------+------------------------------------------|
+   Frame holds the colors of the last finished frame.
+*/
+var Pixels [SCREEN_HEIGHT][SCREEN_WIDTH]uint16
+var Frame = image.NewRGBA(image.Rect(0, 0, SCREEN_WIDTH, SCREEN_HEIGHT))
+
+// Convert the pixels to colors of the current palette
+func renderFrame() {
+	for y := range Pixels {
+		for x, p := range Pixels[y] {
+			color := EmphasisTable[p>>6][p&0x3F]
+			i := Frame.PixOffset(x, y)
+			copy(Frame.Pix[i:i+3], color[:])
+			Frame.Pix[i+3] = 0xFF
+		}
+	}
+}
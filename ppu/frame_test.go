@@ -0,0 +1,148 @@
+package ppu
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/siva0410/emu/casette"
+)
+
+// Render frames into Frame without a window: backdrop $21 and tile 1, a
+// solid square of color 1 of palette 0 ($16), at the top left
+func TestRenderFrame(t *testing.T) {
+	chr := make([]byte, 0x20)
+	for row := 0; row < 8; row++ {
+		chr[0x10+row] = 0xFF
+	}
+	initTestPpu(t, chr)
+
+	setAddr(0x2000)
+	writeReg(0x2007, 0x01)
+	setAddr(0x3F00)
+	writeReg(0x2007, 0x21)
+	writeReg(0x2007, 0x16)
+	setAddr(0x0000)
+	writeReg(0x2001, 0x0A)
+
+	// The first frame starts without the tiles fetched on the pre-render line
+	frames := 0
+	for i := 0; frames < 2 && i < 2*341*262; i++ {
+		cycle := 1
+		if ExecPpu(&cycle) {
+			frames++
+		}
+	}
+	if frames != 2 {
+		t.Fatalf("%d frames rendered, want 2", frames)
+	}
+
+	rgba := func(entry byte) color.RGBA {
+		c := EmphasisTable[0][entry]
+		return color.RGBA{c[0], c[1], c[2], 0xFF}
+	}
+	tests := []struct {
+		x, y int
+		want byte
+	}{
+		{0, 0, 0x16},
+		{7, 0, 0x16},
+		{0, 7, 0x16},
+		{7, 7, 0x16},
+		{8, 0, 0x21},
+		{0, 8, 0x21},
+		{128, 120, 0x21},
+		{255, 239, 0x21},
+	}
+	for _, tt := range tests {
+		if got := Frame.RGBAAt(tt.x, tt.y); got != rgba(tt.want) {
+			t.Errorf("pixel %d,%d: got %v, want %v (entry $%02X)", tt.x, tt.y, got, rgba(tt.want), tt.want)
+		}
+	}
+
+	// Greyscale keeps the column of the palette entry
+	writeReg(0x2001, 0x0B)
+	for i := 0; i < 341*262; i++ {
+		cycle := 1
+		if ExecPpu(&cycle) {
+			break
+		}
+	}
+	if got := Frame.RGBAAt(0, 0); got != rgba(0x10) {
+		t.Errorf("greyscale pixel 0,0: got %v, want %v (entry $10)", got, rgba(0x10))
+	}
+}
+
+// PPUMASK emphasis bits are red, green, blue on NTSC, green, red, blue on
+// PAL and Dendy
+func TestEmphasisRegion(t *testing.T) {
+	tests := []struct {
+		region byte
+		mask   byte
+		want   uint16
+	}{
+		{casette.REGION_NTSC, 0x20, 0b001},
+		{casette.REGION_NTSC, 0x40, 0b010},
+		{casette.REGION_NTSC, 0x80, 0b100},
+		{casette.REGION_PAL, 0x20, 0b010},
+		{casette.REGION_PAL, 0x40, 0b001},
+		{casette.REGION_PAL, 0x80, 0b100},
+		{casette.REGION_PAL, 0xE0, 0b111},
+		{casette.REGION_DENDY, 0x20, 0b010},
+		{casette.REGION_DENDY, 0x60, 0b011},
+	}
+	initTestPpu(t, nil)
+	defer casette.SetRegion(casette.REGION_NTSC)
+	line = 0
+	for _, tt := range tests {
+		casette.SetRegion(tt.region)
+		*Ppu_reg.Ppumask = tt.mask
+		outputPixel(0, 0x16)
+		if got := Pixels[0][0]; got != tt.want<<6|0x16 {
+			t.Errorf("region %d mask $%02X: pixel $%03X, want $%03X", tt.region, tt.mask, got, tt.want<<6|0x16)
+		}
+	}
+}
+
+// PPUMASK enables, left column clipping, greyscale and emphasis of a
+// pixel where the background ($16) covers a sprite behind it ($2A), with
+// the backdrop $21
+func TestRenderPixelMask(t *testing.T) {
+	tests := []struct {
+		name string
+		mask byte
+		px   int
+		want uint16
+	}{
+		{"both", 0x1E, 3, 0x16},
+		{"background clipped", 0x1C, 3, 0x2A},
+		{"sprites clipped", 0x1A, 3, 0x16},
+		{"both clipped", 0x18, 3, 0x21},
+		{"both clipped x=7", 0x18, 7, 0x21},
+		{"both clipped x=8", 0x18, 8, 0x16},
+		{"sprites only", 0x14, 3, 0x2A},
+		{"sprites only clipped", 0x10, 3, 0x21},
+		{"background only", 0x0A, 3, 0x16},
+		{"background only clipped", 0x08, 3, 0x21},
+		{"rendering disabled", 0x00, 8, 0x21},
+		{"greyscale", 0x1F, 8, 0x10},
+		{"greyscale backdrop", 0x19, 3, 0x20},
+		{"emphasis", 0xFE, 8, 0b111<<6 | 0x16},
+		{"greyscale and emphasis", 0x3F, 8, 0b001<<6 | 0x10},
+	}
+	for _, tt := range tests {
+		initTestPpu(t, nil)
+		line = 0
+		PPU_MEM[0x3F00] = 0x21
+		PPU_MEM[0x3F01] = 0x16
+		PPU_MEM[0x3F11] = 0x2A
+		bg_lo, bg_hi = 0xFFFF, 0x0000
+		sprites[0] = sprite{x: byte(tt.px &^ 7), attribute: 0x20, lo: 0xFF}
+		sprite_count = 1
+
+		*Ppu_reg.Ppumask = tt.mask
+		renderPixel(tt.px)
+		if got := Pixels[0][tt.px]; got != tt.want {
+			t.Errorf("%s: pixel %d $%03X, want $%03X", tt.name, tt.px, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package ppu
+
+import "math"
+
+/*
+   NTSC palette generator
+   The PPU outputs a square wave between two voltage levels for each pixel,
+   12 samples per color subcarrier cycle. Colors $x1-$xC are the 12 phases
+   of the wave, $x0 and $xD are grey, $xE and $xF are black. Each emphasis
+   bit attenuates the signal during 6 of the 12 samples. The signal is
+   decoded to YIQ like a TV and converted to RGB.
+   |--------------+---------+-------------------------------------------|
+   | Parameter    | Default | Notes                                     |
+   |--------------+---------+-------------------------------------------|
+   | Hue          | 0       | degrees added to the phase of the colors  |
+   | Saturation   | 1       | scale of I and Q                          |
+   | Contrast     | 1       | scale of Y                                |
+   | Brightness   | 0       | added to Y                                |
+   | Gamma        | 2.2     | display gamma, the TV gamma is 2.2        |
+   |--------------+---------+-------------------------------------------|
+*/
+type NtscParams struct {
+	Hue        float64
+	Saturation float64
+	Contrast   float64
+	Brightness float64
+	Gamma      float64
+}
+
+var DefaultNtscParams = NtscParams{Hue: 0, Saturation: 1, Contrast: 1, Brightness: 0, Gamma: 2.2}
+
+// Signal levels relative to sync, low and high for luma $0x-$3x
+var ntsc_levels = [2][4]float64{
+	{0.350, 0.518, 0.962, 1.550},
+	{1.094, 1.506, 1.962, 1.962},
+}
+
+const (
+	NTSC_BLACK       = 0.518
+	NTSC_WHITE       = 1.962
+	NTSC_ATTENUATION = 0.746
+	// Phase of the color burst relative to color $x1 in samples
+	NTSC_HUE_OFFSET = 4
+)
+
+// Generate the colors of all 64 entries for each emphasis
+func GenerateNtscPalette(params NtscParams) {
+	for e := range EmphasisTable {
+		for i := range EmphasisTable[e] {
+			EmphasisTable[e][i] = ntscColor(i, e, params)
+		}
+	}
+	PalettesTable = EmphasisTable[0]
+}
+
+func ntscColor(entry int, emphasis int, params NtscParams) [3]byte {
+	hue := entry & 0x0F
+	level := entry >> 4 & 0b11
+	if hue >= 0x0E {
+		level = 1
+	}
+	lo := ntsc_levels[0][level]
+	hi := ntsc_levels[1][level]
+	if hue == 0x00 {
+		lo = hi
+	}
+	if hue > 0x0C {
+		hi = lo
+	}
+
+	var y, i, q float64
+	for p := 0; p < 12; p++ {
+		signal := lo
+		if inPhase(hue, p) {
+			signal = hi
+		}
+		// emphasis bits 0-2 attenuate the phases of colors $xC, $x4 and $x8
+		for bit, phase := range [3]int{0xC, 0x4, 0x8} {
+			if emphasis>>bit&0b1 != 0 && inPhase(phase, p) {
+				signal *= NTSC_ATTENUATION
+			}
+		}
+		signal = (signal - NTSC_BLACK) / (NTSC_WHITE - NTSC_BLACK)
+
+		angle := math.Pi * (float64(p) + NTSC_HUE_OFFSET + params.Hue/30) / 6
+		y += signal
+		i += signal * math.Cos(angle)
+		q += signal * math.Sin(angle)
+	}
+	y = y/12*params.Contrast + params.Brightness
+	i = i / 12 * params.Saturation * 2
+	q = q / 12 * params.Saturation * 2
+
+	return [3]byte{
+		gammaFix(y+0.946882*i+0.623557*q, params.Gamma),
+		gammaFix(y-0.274788*i-0.635691*q, params.Gamma),
+		gammaFix(y-1.108545*i+1.709007*q, params.Gamma),
+	}
+}
+
+// The wave of color c is high during 6 of the 12 samples
+func inPhase(c int, p int) bool {
+	return (c+p)%12 < 6
+}
+
+func gammaFix(v float64, gamma float64) byte {
+	if v <= 0 {
+		return 0
+	}
+	v = math.Pow(v, 2.2/gamma)
+	if v >= 1 {
+		return 0xFF
+	}
+	return byte(v * 0xFF)
+}
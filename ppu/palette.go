@@ -1,5 +1,10 @@
 package ppu
 
+import (
+	"fmt"
+	"io/ioutil"
+)
+
 var PalettesTable = [...][3]byte{
 	{0x80, 0x80, 0x80}, {0x00, 0x3D, 0xA6}, {0x00, 0x12, 0xB0}, {0x44, 0x00, 0x96},
 	{0xA1, 0x00, 0x5E}, {0xC7, 0x00, 0x28}, {0xBA, 0x06, 0x00}, {0x8C, 0x17, 0x00},
@@ -19,16 +24,74 @@ var PalettesTable = [...][3]byte{
 	{0x99, 0xFF, 0xFC}, {0xDD, 0xDD, 0xDD}, {0x11, 0x11, 0x11}, {0x11, 0x11, 0x11},
 }
 
-var Palettes [8][4][3]byte
+/*
+   Emphasis (PPUMASK bits 5-7, NTSC order)
+   |-----+---------+-------------------------|
+   | Bit | Channel | Attenuated              |
+   |-----+---------+-------------------------|
+   | 0   | red     | green, blue             |
+   | 1   | green   | red, blue               |
+   | 2   | blue    | red, green              |
+   |-----+---------+-------------------------|
+   PAL and Dendy swap red and green, see casette.Timing.SwapEmphasis
+*/
+var EmphasisTable = makeEmphasisTable(PalettesTable)
+
+const EMPHASIS_ATTENUATION = 0.746
+
+// Colors for each emphasis, a channel is attenuated when any other
+// channel is emphasized
+func makeEmphasisTable(colors [64][3]byte) [8][64][3]byte {
+	var table [8][64][3]byte
+	for e := 0; e < 8; e++ {
+		for i, color := range colors {
+			for c := 0; c < 3; c++ {
+				if e&^(0b1<<c) != 0 {
+					color[c] = byte(float64(color[c]) * EMPHASIS_ATTENUATION)
+				}
+			}
+			table[e][i] = color
+		}
+	}
+	return table
+}
+
+/*
+   .pal file
+   |-------+----------------------------------------------------|
+   | Size  | Contents                                           |
+   |-------+----------------------------------------------------|
+   | 192   | 64 RGB colors, emphasis is computed                |
+   | 1536  | 8 x 64 RGB colors, one table per emphasis (0-7)    |
+   |-------+----------------------------------------------------|
+*/
+func LoadPalette(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
 
-func updatePalette() {
-	for i := 0; i < 8; i++ {
-		for j := 0; j < 4; j++ {
-			if i != 0 && j == 0 {
-				Palettes[i][j] = Palettes[0][0]
-			} else {
-				Palettes[i][j] = PalettesTable[PPU_MEM[0x3f00+4*i+j]]
+	switch len(data) {
+	case 64 * 3:
+		var colors [64][3]byte
+		for i := range colors {
+			copy(colors[i][:], data[3*i:])
+		}
+		setPalette(colors)
+	case 8 * 64 * 3:
+		for e := range EmphasisTable {
+			for i := range EmphasisTable[e] {
+				copy(EmphasisTable[e][i][:], data[3*(64*e+i):])
 			}
 		}
+		PalettesTable = EmphasisTable[0]
+	default:
+		return fmt.Errorf("%s: size %d is not 192 or 1536 bytes", path, len(data))
 	}
+	return nil
+}
+
+func setPalette(colors [64][3]byte) {
+	PalettesTable = colors
+	EmphasisTable = makeEmphasisTable(colors)
 }
@@ -0,0 +1,78 @@
+package ppu
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePalette(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.pal")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPalette(t *testing.T) {
+	palettes, emphasis := PalettesTable, EmphasisTable
+	defer func() { PalettesTable, EmphasisTable = palettes, emphasis }()
+
+	// 64 colors, the emphasis tables are computed
+	data := make([]byte, 64*3)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := LoadPalette(writePalette(t, data)); err != nil {
+		t.Fatal(err)
+	}
+	if c := PalettesTable[0x3F]; c != [3]byte{0xBD, 0xBE, 0xBF} {
+		t.Errorf("192 bytes: color $3F %v, want [189 190 191]", c)
+	}
+	if EmphasisTable[0] != PalettesTable {
+		t.Errorf("192 bytes: emphasis 0 is not the palette")
+	}
+	// Red emphasis attenuates green and blue
+	attenuate := func(c byte) byte {
+		return byte(float64(c) * EMPHASIS_ATTENUATION)
+	}
+	if c := EmphasisTable[0b001][0x3F]; c != [3]byte{0xBD, attenuate(0xBE), attenuate(0xBF)} {
+		t.Errorf("192 bytes: color $3F with red emphasis %v", c)
+	}
+
+	// 8 tables of 64 colors, one per emphasis
+	data = make([]byte, 8*64*3)
+	for e := 0; e < 8; e++ {
+		for i := 0; i < 64; i++ {
+			copy(data[3*(64*e+i):], []byte{byte(e), byte(i), 0xFF - byte(e)})
+		}
+	}
+	if err := LoadPalette(writePalette(t, data)); err != nil {
+		t.Fatal(err)
+	}
+	for e := 0; e < 8; e++ {
+		if c := EmphasisTable[e][0x21]; c != [3]byte{byte(e), 0x21, 0xFF - byte(e)} {
+			t.Errorf("1536 bytes: emphasis %d color $21 %v", e, c)
+		}
+	}
+	if PalettesTable != EmphasisTable[0] {
+		t.Errorf("1536 bytes: the palette is not emphasis 0")
+	}
+
+	// Other sizes are rejected and keep the palette
+	loaded := EmphasisTable
+	for _, size := range []int{0, 191, 193, 1535, 64 * 4} {
+		err := LoadPalette(writePalette(t, make([]byte, size)))
+		if err == nil || !strings.Contains(err.Error(), "is not 192 or 1536 bytes") {
+			t.Errorf("%d bytes: error %v", size, err)
+		}
+		if EmphasisTable != loaded {
+			t.Errorf("%d bytes: palette changed", size)
+		}
+	}
+	if err := LoadPalette(filepath.Join(t.TempDir(), "missing.pal")); err == nil {
+		t.Errorf("missing file: no error")
+	}
+}
@@ -1,83 +1,288 @@
 package ppu
 
 import (
-	"fmt"
-
-	"github.com/go-gl/glfw/v3.3/glfw"
-
 	"github.com/siva0410/emu/casette"
+	"github.com/siva0410/emu/cpu"
 )
 
-var dots [][]*dot
-
 var line int
+var line_dot int
+var odd_frame bool
 
-func InitPpu() {
-	// Read Rom
-	copy(PPU_MEM[CHR_ROM_ADDR:], casette.Chr_rom[:])
+// PPU dots not added to casette.Ppu_m2 yet, in 1/DotsNum CPU cycles
+var m2_phase int
 
+func InitPpu() {
 	Ppu_reg = new(PpuRegister)
 	initPpuRegisters(Ppu_reg)
+	cpu.OamWrite = writeOam
 
-	dots = makeDots()
 	line = 0
+	line_dot = 0
+	odd_frame = false
 }
 
-func ExecPpu(cycle *int, window *glfw.Window) {
+// Run the PPU, true when a frame is finished in Frame
+func ExecPpu(cycle *int) bool {
 	// Update ppu register
+	status_read = cpu.CPU_MEM_READ[0x2002]
 	CheckPpuPtr()
 
-	// Set sprite
-	if *cycle >= 341 {
-		*cycle -= 341
-		line++
-		fmt.Println(line)
-	}
-
-	if (line+1)%8 == 0 && line < 240 {
-		// set sprite
-		sl := (line+1)/8 - 1
-		for sw := 0; sw < 256/8; sw++ {
-			sprite_num := PPU_MEM[0x2000+0x20*sl+sw]
-			for l := 0; l < 8; l++ {
-				for i := 0; i < 8; i++ {
-					s := (PPU_MEM[0x10*int(sprite_num)+l] >> (7 - i)) & 0b1
-					t := (PPU_MEM[0x08+0x10*int(sprite_num)+l] >> (7 - i)) & 0b1
-					dots[sl*8+l][sw*8+i].sprite = s + t<<1
-				}
+	// Run the dots of the last CPU instruction
+	frame := false
+	for ; *cycle > 0; *cycle-- {
+		if stepDot(*cycle) {
+			renderFrame()
+			frame = true
+		}
+	}
+	return frame
+}
+
+/*
+   Vblank flag and $2002 reads
+   |---------------------------+--------------------------------|
+   | $2002 read at             | Result                         |
+   |---------------------------+--------------------------------|
+   | 1 dot before the flag set | flag never set, no NMI         |
+   | dot 1 (flag set), dot 2   | flag read as set, NMI canceled |
+   |---------------------------+--------------------------------|
+   ExecPpu runs the dots of each CPU instruction after it, so a read in
+   the last CPU cycle (3 dots) reaching the flag set is treated as both:
+   the CPU already read the flag as clear, so it is not set at all.
+*/
+const VBLANK_RACE_DOTS = 4
+
+// $2002 was read by the last CPU instruction
+var status_read bool
+
+// NMI output of the PPU, low to high edges signal the CPU
+var nmi_line bool
+
+func updateNmi() {
+	nmi := *Ppu_reg.Ppustatus&0x80 != 0 && GetPpuCtrl("V")
+	if nmi && !nmi_line {
+		cpu.Nmi_pending = true
+	}
+	nmi_line = nmi
+}
+
+// Last line of the frame, 261 on NTSC and 311 on PAL/Dendy
+func preRenderLine() int {
+	return casette.Console.Scanlines - 1
+}
+
+/*
+   Dots of a rendering line
+   |---------+-----------------------------------------------------------|
+   | Dot     | Notes                                                     |
+   |---------+-----------------------------------------------------------|
+   | 0       | idle                                                      |
+   | 1-256   | pixel output, background fetches for tiles 2-33           |
+   |         | every 8 dots: nametable, attribute, pattern lo, pattern hi |
+   |         | coarse X of v incremented after each tile, Y at 256       |
+   | 257     | horizontal position copied from t to v                    |
+   | 257-320 | sprite fetches, 8 dots per slot                           |
+   | 280-304 | pre-render line: vertical position copied from t          |
+   | 321-336 | background fetches for tiles 0-1 of the next line         |
+   | 337-340 | nametable byte of the next tile twice                     |
+   |---------+-----------------------------------------------------------|
+   The background shift registers shift at dots 2-257 and 322-337 and are
+   reloaded with the fetched tile every 8 dots. On NTSC the pre-render line
+   is one dot shorter on odd frames when rendering is enabled.
+*/
+func stepDot(remaining int) bool {
+	rendering := GetPpuMask("b") || GetPpuMask("s")
+	pre_render := line == preRenderLine()
+
+	if rendering && (line < 240 || pre_render) {
+		renderDot(pre_render)
+	} else if line < 240 && line_dot >= 1 && line_dot <= 256 {
+		// Rendering disabled shows the backdrop, or the palette entry at v
+		// when v points to palette RAM
+		color := PPU_MEM[0x3F00]
+		if ppu_v&0x3FFF >= 0x3F00 {
+			color = PPU_MEM[paletteAddr(ppu_v)]
+		}
+		outputPixel(line_dot-1, color)
+	}
+
+	if line_dot == 1 {
+		switch line {
+		case casette.Console.VblankLine:
+			// a $2002 read in the same CPU cycle sees the flag clear
+			if !(status_read && remaining <= VBLANK_RACE_DOTS) {
+				setPpuStatus("V", true)
 			}
+			updateNmi()
+		case preRenderLine():
+			setPpuStatus("V", false)
+			setPpuStatus("S", false)
+			setPpuStatus("O", false)
+			updateNmi()
 		}
 	}
 
-	if (line+1)%16 == 0 && line < 240 {
-		// set palette
-		pl := (line+1)/16 - 1
-		for pw := 0; pw < 256/16; pw++ {
-			palette_num := PPU_MEM[0x2000+0x03C0+0x4*pl+int(pw/4)]
-			for l := 0; l < 16; l++ {
-				for i := 0; i < 16; i++ {
-					switch {
-					case i < 8 && l < 8:
-						dots[pl*16+l][pw*16+i].palette = (palette_num >> 0) & 0b11
-					case i >= 8 && l < 8:
-						dots[pl*16+l][pw*16+i].palette = (palette_num >> 2) & 0b11
-					case i < 8 && l >= 8:
-						dots[pl*16+l][pw*16+i].palette = (palette_num >> 4) & 0b11
-					case i >= 8 && l >= 8:
-						dots[pl*16+l][pw*16+i].palette = (palette_num >> 6) & 0b11
-					}
-				}
+	// CPU cycles seen by mappers watching the PPU bus
+	m2_phase += casette.Console.DotsDen
+	for m2_phase >= casette.Console.DotsNum {
+		m2_phase -= casette.Console.DotsNum
+		casette.Ppu_m2++
+	}
+
+	line_dot++
+	if pre_render && line_dot == 340 && odd_frame && rendering && casette.Console.SkipOddDot {
+		line_dot++
+	}
+	if line_dot < 341 {
+		return false
+	}
+
+	line_dot = 0
+	line++
+	if line < casette.Console.Scanlines {
+		return false
+	}
+	line = 0
+	odd_frame = !odd_frame
+	return true
+}
+
+func renderDot(pre_render bool) {
+	d := line_dot
+	if d >= 2 && d <= 257 || d >= 322 && d <= 337 {
+		shiftBackground()
+	}
+
+	if d >= 1 && d <= 257 || d >= 321 && d <= 337 {
+		switch (d - 1) & 0b111 {
+		case 0:
+			reloadBackground()
+			// 257 and 337 only reload, 337 fetches the unused nametable byte below
+			if d <= 256 || d >= 321 && d <= 336 {
+				tile_num = readPpuMem(0x2000 | ppu_v&0x0FFF)
 			}
+		case 2:
+			fetchAttribute()
+		case 4:
+			next_tile.lo = readPpuMem(bgPattern())
+		case 6:
+			next_tile.hi = readPpuMem(bgPattern() + 0x08)
+		case 7:
+			incrementX()
+		}
+	}
+
+	switch {
+	case d == 256:
+		incrementY()
+		// Sprites for the next line, none are evaluated on the pre-render line
+		if pre_render {
+			secondary_count = 0
+			sprite_zero_next = false
+		} else {
+			evaluateSprites(line)
 		}
+	case d == 257:
+		copyX()
+	case d >= 280 && d <= 304 && pre_render:
+		copyY()
+	case d == 337 || d == 339:
+		tile_num = readPpuMem(0x2000 | ppu_v&0x0FFF)
 	}
 
-	if line == 262 {
-		line = 0
-		updatePalette()
+	if d >= 257 && d <= 320 {
+		fetchSprite(line, d-257)
+		oam_addr = 0
+	}
 
-		draw(dots)
+	if d >= 1 && d <= 256 && !pre_render {
+		renderPixel(d - 1)
+	}
+}
 
-		glfw.PollEvents()
-		window.SwapBuffers()
+type tile struct {
+	palette byte
+	lo      byte
+	hi      byte
+}
+
+// Latches of the tile being fetched
+var tile_num byte
+var next_tile tile
+
+// Background shift registers, the pixel at x is bit 15-x
+var bg_lo, bg_hi uint16
+var attr_lo, attr_hi uint16
+
+func shiftBackground() {
+	bg_lo <<= 1
+	bg_hi <<= 1
+	attr_lo <<= 1
+	attr_hi <<= 1
+}
+
+// Load the fetched tile into the low 8 bits of the shift registers
+func reloadBackground() {
+	bg_lo = bg_lo&0xFF00 | uint16(next_tile.lo)
+	bg_hi = bg_hi&0xFF00 | uint16(next_tile.hi)
+	attr_lo = attr_lo & 0xFF00
+	if next_tile.palette&0b01 != 0 {
+		attr_lo |= 0xFF
+	}
+	attr_hi = attr_hi & 0xFF00
+	if next_tile.palette&0b10 != 0 {
+		attr_hi |= 0xFF
+	}
+}
+
+/*
+   Tile at v
+   Nametable: 0x2000 | v&0x0FFF
+   Attribute: 0x23C0 | nametable select | coarse Y/4 | coarse X/4
+   Pattern:   table + tile*16 + fine Y
+*/
+func fetchAttribute() {
+	attribute := readPpuMem(0x23C0 | ppu_v&0x0C00 | ppu_v>>4&0x38 | ppu_v>>2&0x07)
+	shift := ppu_v>>4&0x04 | ppu_v&0x02
+	next_tile.palette = (attribute >> shift) & 0b11
+}
+
+func bgPattern() uint16 {
+	var bg_table uint16 = 0x0000
+	if GetPpuCtrl("B") {
+		bg_table = 0x1000
+	}
+	return bg_table + 0x10*uint16(tile_num) + ppu_v>>12&0b111
+}
+
+// Background and sprite pixel at x of the line, the left 8 pixels are
+// hidden by PPUMASK m/M
+func renderPixel(px int) {
+	var pixel, palette byte
+	if GetPpuMask("b") && (px >= 8 || GetPpuMask("m")) {
+		bit := 15 - ppu_x
+		pixel = byte(bg_lo>>bit&0b1 | bg_hi>>bit&0b1<<1)
+		palette = byte(attr_lo>>bit&0b1 | attr_hi>>bit&0b1<<1)
+	}
+	if GetPpuMask("s") && (px >= 8 || GetPpuMask("M")) {
+		pixel, palette = spritePixel(px, pixel, palette)
+	}
+	// transparent pixels show the backdrop color at $3F00
+	if pixel == 0 {
+		palette = 0
+	}
+	outputPixel(px, PPU_MEM[paletteAddr(uint16(4*palette+pixel))])
+}
+
+// Store the color of the pixel with PPUMASK greyscale and emphasis
+func outputPixel(px int, color byte) {
+	if GetPpuMask("G") {
+		color &= 0x30
+	}
+	emphasis := *Ppu_reg.Ppumask >> 5
+	if casette.Console.SwapEmphasis {
+		emphasis = emphasis&0b100 | emphasis&0b001<<1 | emphasis>>1&0b001
 	}
+	Pixels[line][px] = uint16(emphasis)<<6 | uint16(color)
 }
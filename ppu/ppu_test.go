@@ -0,0 +1,216 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/siva0410/emu/casette"
+	"github.com/siva0410/emu/cpu"
+)
+
+func vblank() bool {
+	return *Ppu_reg.Ppustatus&0x80 != 0
+}
+
+// Step single dots up to the dot of the line
+func runTo(t *testing.T, to_line int, to_dot int) {
+	t.Helper()
+	for i := 0; i < 341*312; i++ {
+		if line == to_line && line_dot == to_dot {
+			return
+		}
+		stepDot(341)
+	}
+	t.Fatalf("line %d dot %d not reached", to_line, to_dot)
+}
+
+func TestVblankFlag(t *testing.T) {
+	for _, region := range []byte{casette.REGION_NTSC, casette.REGION_PAL, casette.REGION_DENDY} {
+		initTestPpu(t, nil)
+		casette.SetRegion(region)
+		vblank_line, pre_render := casette.Console.VblankLine, preRenderLine()
+
+		runTo(t, vblank_line, 1)
+		if vblank() {
+			t.Errorf("region %d: vblank set before line %d dot 1", region, vblank_line)
+		}
+		stepDot(341)
+		if !vblank() {
+			t.Errorf("region %d: vblank clear after line %d dot 1", region, vblank_line)
+		}
+
+		setPpuStatus("S", true)
+		setPpuStatus("O", true)
+		runTo(t, pre_render, 1)
+		if !vblank() {
+			t.Errorf("region %d: vblank cleared before line %d dot 1", region, pre_render)
+		}
+		stepDot(341)
+		if *Ppu_reg.Ppustatus&0xE0 != 0 {
+			t.Errorf("region %d: status $%02X after line %d dot 1, want vblank, hit and overflow clear", region, *Ppu_reg.Ppustatus, pre_render)
+		}
+	}
+	casette.SetRegion(casette.REGION_NTSC)
+}
+
+/*
+   ExecPpu runs the dots of a CPU instruction after it, a $2002 read in
+   the instruction whose last VBLANK_RACE_DOTS dots reach line 241 dot 1
+   suppresses the flag and the NMI of the frame
+*/
+func TestVblankRace(t *testing.T) {
+	tests := []struct {
+		name string
+		line int
+		dot  int
+		dots int
+		read bool
+		want bool
+	}{
+		{"no read", 240, 338, 6, false, true},
+		{"read at the flag set", 240, 338, 6, true, false},
+		{"read a dot before the flag set", 240, 337, 6, true, false},
+		{"read before the race", 240, 330, 21, true, true},
+		{"read after the flag set", 241, 2, 3, true, false},
+	}
+	for _, tt := range tests {
+		initTestPpu(t, nil)
+		*Ppu_reg.Ppuctrl = 0x80
+		line, line_dot = tt.line, tt.dot
+		if tt.line == 241 {
+			setPpuStatus("V", true)
+			updateNmi()
+			cpu.Nmi_pending = false
+		}
+
+		cpu.CPU_MEM_READ[0x2002] = tt.read
+		cycle := tt.dots
+		ExecPpu(&cycle)
+		if vblank() != tt.want || cpu.Nmi_pending != tt.want {
+			t.Errorf("%s: vblank %t NMI %t, want %t", tt.name, vblank(), cpu.Nmi_pending, tt.want)
+		}
+	}
+}
+
+func TestNmiEnable(t *testing.T) {
+	initTestPpu(t, nil)
+
+	// Enabling NMI outside vblank doesn't raise it
+	writeReg(0x2000, 0x80)
+	if cpu.Nmi_pending {
+		t.Errorf("NMI raised outside vblank")
+	}
+	writeReg(0x2000, 0x00)
+
+	// Vblank with NMI disabled
+	runTo(t, 241, 2)
+	if !vblank() || cpu.Nmi_pending {
+		t.Fatalf("vblank %t NMI %t, want vblank without NMI", vblank(), cpu.Nmi_pending)
+	}
+
+	// Enabling NMI during vblank raises it immediately
+	writeReg(0x2000, 0x80)
+	if !cpu.Nmi_pending {
+		t.Errorf("NMI not raised by enabling it in vblank")
+	}
+
+	// Writing PPUCTRL again without a low to high edge doesn't
+	cpu.Nmi_pending = false
+	writeReg(0x2000, 0x80)
+	if cpu.Nmi_pending {
+		t.Errorf("NMI raised again without an edge")
+	}
+
+	// Disabling and enabling it again does
+	writeReg(0x2000, 0x00)
+	writeReg(0x2000, 0x80)
+	if !cpu.Nmi_pending {
+		t.Errorf("NMI not raised by enabling it again")
+	}
+
+	// Not after the flag is cleared by a $2002 read
+	cpu.Nmi_pending = false
+	writeReg(0x2000, 0x00)
+	readReg(0x2002)
+	writeReg(0x2000, 0x80)
+	if cpu.Nmi_pending {
+		t.Errorf("NMI raised after the vblank flag was read")
+	}
+}
+
+// Mapper recording the PPU bus addresses with the dot of each access
+type busRecorder struct {
+	casette.Mapper
+	dots  []int
+	addrs []uint16
+}
+
+func (r *busRecorder) PpuAddr(addr uint16) {
+	r.dots = append(r.dots, line_dot)
+	r.addrs = append(r.addrs, addr)
+	r.Mapper.PpuAddr(addr)
+}
+
+/*
+   Fetches of a visible line
+   |---------+----------------------------------------------|
+   | Dot     | Fetch every 8 dots from dot 1, 257 and 321   |
+   |---------+----------------------------------------------|
+   | 1-256   | nametable, attribute, pattern lo, pattern hi |
+   | 257-320 | nametable, nametable, pattern lo, pattern hi |
+   | 321-336 | nametable, attribute, pattern lo, pattern hi |
+   | 337-340 | nametable at 337 and 339                     |
+   |---------+----------------------------------------------|
+*/
+func TestFetchOrder(t *testing.T) {
+	initTestPpu(t, nil)
+	recorder := &busRecorder{Mapper: casette.Cart_mapper}
+	casette.Cart_mapper = recorder
+	defer func() { casette.Cart_mapper = recorder.Mapper }()
+
+	const (
+		NT = "nametable"
+		AT = "attribute"
+		PT = "pattern"
+	)
+	type fetch struct {
+		dot  int
+		kind string
+	}
+	var want []fetch
+	for d := 1; d <= 336; d += 8 {
+		kinds := []string{NT, AT, PT, PT}
+		if d >= 257 && d <= 320 {
+			kinds = []string{NT, NT, PT, PT}
+		}
+		for i, kind := range kinds {
+			want = append(want, fetch{d + 2*i, kind})
+		}
+	}
+	want = append(want, fetch{337, NT}, fetch{339, NT})
+
+	*Ppu_reg.Ppumask = 0x18
+	line, line_dot = 10, 0
+	for line == 10 {
+		stepDot(341)
+	}
+
+	var got []fetch
+	for i, addr := range recorder.addrs {
+		kind := PT
+		switch {
+		case addr >= 0x2000 && addr&0x03FF >= 0x03C0:
+			kind = AT
+		case addr >= 0x2000:
+			kind = NT
+		}
+		got = append(got, fetch{recorder.dots[i], kind})
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d fetches, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("fetch %d: %s at dot %d, want %s at dot %d", i, got[i].kind, got[i].dot, want[i].kind, want[i].dot)
+		}
+	}
+}
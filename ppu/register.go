@@ -88,9 +88,9 @@ func GetPpuCtrl(flagname string) bool {
    |-----------------------------------+-----|
    | PPUMASK                           | bit |
    |-----------------------------------+-----|
-   | color emphasis (R)                |   7 |
+   | color emphasis (B)                |   7 |
    | color emphasis (G)                |   6 |
-   | color emphasis (B)                |   5 |
+   | color emphasis (R)                |   5 |
    | sprite enable (s)                 |   4 |
    | background enable (b)             |   3 |
    | sprite left column enable (M)     |   2 |
@@ -111,11 +111,11 @@ func GetPpuMask(flagname string) bool {
 		status = *Ppu_reg.Ppumask >> 3 & 0b1
 	case "s":
 		status = *Ppu_reg.Ppumask >> 4 & 0b1
-	case "RGB_B":
+	case "RGB_R":
 		status = *Ppu_reg.Ppumask >> 5 & 0b1
 	case "RGB_G":
 		status = *Ppu_reg.Ppumask >> 6 & 0b1
-	case "RGB_R":
+	case "RGB_B":
 		status = *Ppu_reg.Ppumask >> 7 & 0b1
 	}
 
@@ -137,31 +137,191 @@ func GetPpuMask(flagname string) bool {
    | read resets write pair for %2005/%2006  |   4 |
    |-----------------------------------------+-----|
 */
+func setPpuStatus(flagname string, value bool) {
+	var mask byte
+	switch flagname {
+	case "O":
+		mask = 0b1 << 5
+	case "S":
+		mask = 0b1 << 6
+	case "V":
+		mask = 0b1 << 7
+	}
+
+	if value {
+		*Ppu_reg.Ppustatus |= mask
+	} else {
+		*Ppu_reg.Ppustatus &^= mask
+	}
+}
 
-var ppu_addr_flag bool = false
+/*
+   Internal registers
+   |----------+---------+------------------------------------------|
+   | Register | Bits    | Notes                                    |
+   |----------+---------+------------------------------------------|
+   | v        | 15      | current VRAM address                     |
+   | t        | 15      | temporary VRAM address, top left of view |
+   | x        | 3       | fine X scroll                            |
+   | w        | 1       | first or second write toggle             |
+   |----------+---------+------------------------------------------|
 
+   v and t while rendering
+   yyy NN YYYYY XXXXX
+   ||| || ||||| +++++-- coarse X scroll
+   ||| || +++++-------- coarse Y scroll
+   ||| ++-------------- nametable select
+   +++----------------- fine Y scroll
+*/
+var ppu_v uint16
+var ppu_t uint16
+var ppu_x byte
+var ppu_w bool
+
+/*
+   |-------------+---+-------------------------------------------------|
+   | Access      | w | Notes                                           |
+   |-------------+---+-------------------------------------------------|
+   | $2000 write |   | t: ...GH.. ........ <- d: ......GH              |
+   | $2002 read  |   | w = 0, vblank flag cleared                      |
+   | $2003 write |   | OAM address                                     |
+   | $2004 write |   | OAM[address] <- d, address += 1                 |
+   | $2005 write | 0 | t: ....... ...ABCDE <- d: ABCDE...              |
+   |             |   | x: FGH <- d: .....FGH                           |
+   | $2005 write | 1 | t: FGH..AB CDE..... <- d: ABCDEFGH              |
+   | $2006 write | 0 | t: .CDEFGH ........ <- d: ..CDEFGH (bit 14 = 0) |
+   | $2006 write | 1 | t: ....... ABCDEFGH <- d: ABCDEFGH, v = t       |
+   | $2007 write |   | [v] <- d, v += 1 or 32 (PPUCTRL I)              |
+   | $2007 read  |   | buffer <- [v], v += 1 or 32 (PPUCTRL I)         |
+   |-------------+---+-------------------------------------------------|
+   PPUDATA reads return the buffer filled by the previous read, except
+   palette RAM which is read directly while the buffer gets the nametable
+   byte under it ($3F00-$3FFF - $1000). v is 15 bits but only the low 14
+   are on the PPU bus, so $3FFF + 1 accesses $0000.
+*/
 func CheckPpuPtr() {
-	if cpu.CPU_MEM_CHK[0x2007] {
-		PPU_MEM[PPU_PTR] = *Ppu_reg.Ppudata
-		// check ppu_mem_chk
-		PPU_MEM_CHK[PPU_PTR] = true
-		if !GetPpuCtrl("I") {
-			PPU_PTR += 0x1
+	if cpu.CPU_MEM_CHK[0x2000] {
+		ppu_t = ppu_t&^0x0C00 | uint16(*Ppu_reg.Ppuctrl&0b11)<<10
+		// enabling NMI during vblank raises it immediately
+		updateNmi()
+		cpu.CPU_MEM_CHK[0x2000] = false
+	}
+
+	if cpu.CPU_MEM_READ[0x2002] {
+		ppu_w = false
+		setPpuStatus("V", false)
+		updateNmi()
+		cpu.CPU_MEM_READ[0x2002] = false
+	}
+
+	if cpu.CPU_MEM_CHK[0x2003] {
+		oam_addr = *Ppu_reg.Oamaddr
+		cpu.CPU_MEM_CHK[0x2003] = false
+	}
+
+	if cpu.CPU_MEM_CHK[0x2004] {
+		writeOam(*Ppu_reg.Oamdata)
+		cpu.CPU_MEM_CHK[0x2004] = false
+	}
+	// OAMDATA reads see the byte at the current address
+	*Ppu_reg.Oamdata = OAM[oam_addr]
+
+	if cpu.CPU_MEM_CHK[0x2005] {
+		data := uint16(*Ppu_reg.Ppuscroll)
+		if !ppu_w {
+			ppu_t = ppu_t&^0x001F | data>>3
+			ppu_x = byte(data & 0b111)
 		} else {
-			PPU_PTR += 0x20
+			ppu_t = ppu_t&^0x73E0 | (data&0b111)<<12 | (data&0xF8)<<2
 		}
-		cpu.CPU_MEM_CHK[0x2007] = false
+		ppu_w = !ppu_w
+		cpu.CPU_MEM_CHK[0x2005] = false
 	}
 
 	if cpu.CPU_MEM_CHK[0x2006] {
-		PPU_PTR = PPU_PTR << 0x8
-		PPU_PTR += uint32(*Ppu_reg.Ppuaddr)
-		PPU_PTR &= 0xFFFF
-		if ppu_addr_flag {
-			ppu_addr_flag = false
+		data := uint16(*Ppu_reg.Ppuaddr)
+		if !ppu_w {
+			ppu_t = ppu_t&0x00FF | (data&0x3F)<<8
 		} else {
-			ppu_addr_flag = true
+			ppu_t = ppu_t&0xFF00 | data
+			ppu_v = ppu_t
 		}
+		ppu_w = !ppu_w
 		cpu.CPU_MEM_CHK[0x2006] = false
 	}
+
+	if cpu.CPU_MEM_CHK[0x2007] {
+		writePpuMem(ppu_v&0x3FFF, *Ppu_reg.Ppudata)
+		incrementAddr()
+		cpu.CPU_MEM_CHK[0x2007] = false
+	}
+
+	if cpu.CPU_MEM_READ[0x2007] {
+		addr := ppu_v & 0x3FFF
+		if addr >= 0x3F00 {
+			addr -= 0x1000
+		}
+		read_buffer = readPpuMem(addr)
+		incrementAddr()
+		cpu.CPU_MEM_READ[0x2007] = false
+	}
+	// PPUDATA reads see the buffer or the palette RAM at v
+	if ppu_v&0x3FFF >= 0x3F00 {
+		*Ppu_reg.Ppudata = PPU_MEM[paletteAddr(ppu_v)]
+	} else {
+		*Ppu_reg.Ppudata = read_buffer
+	}
+}
+
+// PPUDATA read buffer
+var read_buffer byte
+
+func incrementAddr() {
+	if !GetPpuCtrl("I") {
+		ppu_v += 0x1
+	} else {
+		ppu_v += 0x20
+	}
+	ppu_v &= 0x7FFF
+}
+
+// Increment coarse X, wrapping to the next horizontal nametable
+func incrementX() {
+	if ppu_v&0x001F == 31 {
+		ppu_v &^= 0x001F
+		ppu_v ^= 0x0400
+	} else {
+		ppu_v++
+	}
+}
+
+// Increment fine Y, then coarse Y wrapping to the next vertical nametable
+// after row 29 (rows 30 and 31 are attributes and wrap in place)
+func incrementY() {
+	if ppu_v&0x7000 != 0x7000 {
+		ppu_v += 0x1000
+		return
+	}
+	ppu_v &^= 0x7000
+	y := ppu_v & 0x03E0 >> 5
+	switch y {
+	case 29:
+		y = 0
+		ppu_v ^= 0x0800
+	case 31:
+		y = 0
+	default:
+		y++
+	}
+	ppu_v = ppu_v&^0x03E0 | y<<5
+}
+
+// Copy horizontal position (coarse X, nametable X) from t to v
+func copyX() {
+	ppu_v = ppu_v&^0x041F | ppu_t&0x041F
+}
+
+// Copy vertical position (fine Y, coarse Y, nametable Y) from t to v
+func copyY() {
+	ppu_v = ppu_v&^0x7BE0 | ppu_t&0x7BE0
 }
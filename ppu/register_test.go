@@ -0,0 +1,172 @@
+package ppu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/siva0410/emu/casette"
+	"github.com/siva0410/emu/cpu"
+)
+
+// Load an NROM cartridge with the CHR ROM and reset the PPU state
+func initTestPpu(t *testing.T, chr []byte) {
+	t.Helper()
+	rom := []byte{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	rom = append(rom, make([]byte, 0x4000)...)
+	rom = append(rom, chr...)
+	rom = append(rom, make([]byte, 0x2000-len(chr))...)
+	path := filepath.Join(t.TempDir(), "test.nes")
+	if err := os.WriteFile(path, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := casette.SetRom(path); err != nil {
+		t.Fatal(err)
+	}
+	casette.SetRegion(casette.REGION_NTSC)
+
+	for addr := 0x2000; addr <= 0x2007; addr++ {
+		cpu.CPU_MEM[addr] = 0
+		cpu.CPU_MEM_CHK[addr] = false
+		cpu.CPU_MEM_READ[addr] = false
+	}
+	cpu.Nmi_pending = false
+	PPU_MEM = [0x4000]byte{}
+	OAM = [0x100]byte{}
+	InitPpu()
+
+	ppu_v, ppu_t, ppu_x, ppu_w = 0, 0, 0, false
+	read_buffer = 0
+	oam_addr = 0
+	nmi_line, status_read = false, false
+	m2_phase = 0
+	bg_lo, bg_hi, attr_lo, attr_hi = 0, 0, 0, 0
+	sprites, sprite_count, sprite_zero = [8]sprite{}, 0, false
+	secondary_count, sprite_zero_next = 0, false
+}
+
+// CPU write to a PPU register
+func writeReg(addr uint16, data byte) {
+	cpu.CPU_MEM[addr] = data
+	cpu.CPU_MEM_CHK[addr] = true
+	CheckPpuPtr()
+}
+
+// CPU read of a PPU register, the side effects follow the read
+func readReg(addr uint16) byte {
+	data := cpu.CPU_MEM[addr]
+	cpu.CPU_MEM_READ[addr] = true
+	CheckPpuPtr()
+	return data
+}
+
+func TestIncrementX(t *testing.T) {
+	tests := []struct {
+		v    uint16
+		want uint16
+	}{
+		{0x0000, 0x0001},
+		{0x001E, 0x001F},
+		// coarse X 31 wraps to the next horizontal nametable
+		{0x001F, 0x0400},
+		{0x041F, 0x0000},
+		{0x0C1F, 0x0800},
+		// fine Y, coarse Y and nametable Y are kept
+		{0x7BFF, 0x7FE0},
+	}
+	for _, tt := range tests {
+		ppu_v = tt.v
+		incrementX()
+		if ppu_v != tt.want {
+			t.Errorf("v $%04X: got $%04X, want $%04X", tt.v, ppu_v, tt.want)
+		}
+	}
+}
+
+func TestIncrementY(t *testing.T) {
+	tests := []struct {
+		v    uint16
+		want uint16
+	}{
+		{0x0000, 0x1000},
+		{0x6000, 0x7000},
+		// fine Y 7 wraps to the next coarse Y
+		{0x7000, 0x0020},
+		{0x7345, 0x0365},
+		// coarse Y 29 wraps to the next vertical nametable
+		{0x73A0, 0x0800},
+		{0x7BA0, 0x0000},
+		{0x77A5, 0x0C05},
+		// coarse Y 30 and 31 are attribute rows, 31 wraps in place
+		{0x7BC0, 0x0BE0},
+		{0x73E0, 0x0000},
+		{0x7BE0, 0x0800},
+	}
+	for _, tt := range tests {
+		ppu_v = tt.v
+		incrementY()
+		if ppu_v != tt.want {
+			t.Errorf("v $%04X: got $%04X, want $%04X", tt.v, ppu_v, tt.want)
+		}
+	}
+}
+
+func TestCopyXY(t *testing.T) {
+	tests := []struct {
+		name string
+		copy func()
+		v, t uint16
+		want uint16
+	}{
+		{"copyX", copyX, 0x0000, 0x7FFF, 0x041F},
+		{"copyX", copyX, 0x7FFF, 0x0000, 0x7BE0},
+		{"copyY", copyY, 0x0000, 0x7FFF, 0x7BE0},
+		{"copyY", copyY, 0x7FFF, 0x0000, 0x041F},
+	}
+	for _, tt := range tests {
+		ppu_v, ppu_t = tt.v, tt.t
+		tt.copy()
+		if ppu_v != tt.want {
+			t.Errorf("%s v $%04X t $%04X: got $%04X, want $%04X", tt.name, tt.v, tt.t, ppu_v, tt.want)
+		}
+	}
+}
+
+func TestScrollWrites(t *testing.T) {
+	type access struct {
+		addr uint16
+		data byte
+	}
+	tests := []struct {
+		name     string
+		accesses []access
+		t, v     uint16
+		x        byte
+		w        bool
+	}{
+		{"nametable", []access{{0x2000, 0x03}}, 0x0C00, 0x0000, 0, false},
+		{"scroll X", []access{{0x2005, 0x7D}}, 0x000F, 0x0000, 5, true},
+		{"scroll X and Y", []access{{0x2005, 0x7D}, {0x2005, 0x5E}}, 0x616F, 0x0000, 5, false},
+		{"address", []access{{0x2006, 0x3D}, {0x2006, 0xF0}}, 0x3DF0, 0x3DF0, 0, false},
+		// the first $2006 write clears bit 14
+		{"address bit 14", []access{{0x2005, 0xFF}, {0x2005, 0xFF}, {0x2006, 0x7F}}, 0x3FFF, 0x0000, 7, true},
+		// a $2002 read resets the write toggle
+		{"status read", []access{{0x2005, 0x7D}, {0x2002, 0}, {0x2005, 0x5E}}, 0x000B, 0x0000, 6, true},
+		{"split", []access{{0x2000, 0x00}, {0x2002, 0}, {0x2005, 0x7D}, {0x2005, 0x5E}, {0x2006, 0x3D}, {0x2006, 0xF0}}, 0x3DF0, 0x3DF0, 5, false},
+		{"nametable and address", []access{{0x2006, 0x00}, {0x2000, 0x02}, {0x2006, 0x00}}, 0x0800, 0x0800, 0, false},
+	}
+	for _, tt := range tests {
+		initTestPpu(t, nil)
+		for _, a := range tt.accesses {
+			if a.addr == 0x2002 {
+				readReg(a.addr)
+			} else {
+				writeReg(a.addr, a.data)
+			}
+		}
+		if ppu_t != tt.t || ppu_v != tt.v || ppu_x != tt.x || ppu_w != tt.w {
+			t.Errorf("%s: t $%04X v $%04X x %d w %t, want t $%04X v $%04X x %d w %t",
+				tt.name, ppu_t, ppu_v, ppu_x, ppu_w, tt.t, tt.v, tt.x, tt.w)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package ppu
+
+/*
+   OAM entry
+   |------+-----------+---------------------------------------------|
+   | Byte | Bits      | Notes                                       |
+   |------+-----------+---------------------------------------------|
+   | 0    | yyyy yyyy | Y position of the top minus 1               |
+   | 1    | tttt tttt | tile, 8x16: bank ($0000/$1000) in bit 0     |
+   | 2    | VHP- --pp | flip V/H, priority (1: behind bg), palette  |
+   | 3    | xxxx xxxx | X position of the left side                 |
+   |------+-----------+---------------------------------------------|
+*/
+var OAM [0x100]byte
+var secondary_oam [0x20]byte
+var oam_addr byte
+
+type sprite struct {
+	x         byte
+	attribute byte
+	lo        byte
+	hi        byte
+}
+
+// Sprites of the line being drawn, fetched at the end of the previous line
+var sprites [8]sprite
+var sprite_count int
+
+// Sprites found by evaluation for the next line
+var secondary_count int
+
+// Sprite 0 is in slot 0 of the next line / of the line being drawn
+var sprite_zero_next bool
+var sprite_zero bool
+
+// Write OAMDATA, the unimplemented attribute bits 2-4 read back as 0
+func writeOam(data byte) {
+	if oam_addr&0b11 == 2 {
+		data &= 0xE3
+	}
+	OAM[oam_addr] = data
+	oam_addr++
+}
+
+func spriteHeight() int {
+	if GetPpuCtrl("H") {
+		return 16
+	}
+	return 8
+}
+
+/*
+   Sprite evaluation for the next line
+   Up to 8 sprites in range are copied to secondary OAM. After the 8th
+   sprite the PPU keeps looking for overflow, but increments the byte
+   index m together with the sprite index n, so it compares tiles,
+   attributes and X positions as Y (hardware bug).
+*/
+func evaluateSprites(line int) {
+	for i := range secondary_oam {
+		secondary_oam[i] = 0xFF
+	}
+
+	height := spriteHeight()
+	inRange := func(y byte) bool {
+		row := line - int(y)
+		return row >= 0 && row < height
+	}
+
+	n, count := 0, 0
+	sprite_zero_next = inRange(OAM[0])
+	for ; n < 64 && count < 8; n++ {
+		if inRange(OAM[4*n]) {
+			copy(secondary_oam[4*count:4*count+4], OAM[4*n:4*n+4])
+			count++
+		}
+	}
+
+	m := 0
+	for ; n < 64; n++ {
+		if inRange(OAM[4*n+m]) {
+			setPpuStatus("O", true)
+			break
+		}
+		m = (m + 1) & 0b11
+	}
+	secondary_count = count
+}
+
+/*
+   Sprite fetch, 8 dots per slot
+   |-----+--------------------------|
+   | Dot | Fetch                    |
+   |-----+--------------------------|
+   | 0   | garbage nametable byte   |
+   | 2   | garbage nametable byte   |
+   | 4   | pattern lo               |
+   | 6   | pattern hi               |
+   |-----+--------------------------|
+   Unused slots fetch tile $FF
+*/
+func fetchSprite(line int, offset int) {
+	i := offset / 8
+	if offset == 0 {
+		sprite_count = secondary_count
+		sprite_zero = sprite_zero_next && secondary_count > 0
+	}
+
+	switch offset & 0b111 {
+	case 0, 2:
+		readPpuMem(0x2000 | ppu_v&0x0FFF)
+	case 4:
+		sprites[i].lo = readPpuMem(spritePattern(line, i))
+	case 6:
+		sprites[i].hi = readPpuMem(spritePattern(line, i) + 0x08)
+		sprites[i].attribute = secondary_oam[4*i+2]
+		sprites[i].x = secondary_oam[4*i+3]
+		if sprites[i].attribute&0x40 != 0 {
+			sprites[i].lo = reverseBits(sprites[i].lo)
+			sprites[i].hi = reverseBits(sprites[i].hi)
+		}
+	}
+}
+
+// Pattern address of the row of slot i on the next line
+func spritePattern(line int, i int) uint16 {
+	height := spriteHeight()
+	y, tile_num, attribute := secondary_oam[4*i], secondary_oam[4*i+1], secondary_oam[4*i+2]
+	row := 0
+	if i < secondary_count {
+		row = line - int(y)
+	}
+	if attribute&0x80 != 0 {
+		row = height - 1 - row
+	}
+
+	var table uint16 = 0x0000
+	if height == 16 {
+		table = uint16(tile_num&0b1) * 0x1000
+		tile_num &= 0xFE
+		if row >= 8 {
+			tile_num++
+			row -= 8
+		}
+	} else if GetPpuCtrl("S") {
+		table = 0x1000
+	}
+	return table + 0x10*uint16(tile_num) + uint16(row)
+}
+
+func reverseBits(b byte) byte {
+	b = b&0xF0>>4 | b&0x0F<<4
+	b = b&0xCC>>2 | b&0x33<<2
+	b = b&0xAA>>1 | b&0x55<<1
+	return b
+}
+
+/*
+   Sprite pixel over the background pixel at x
+   The first opaque sprite in OAM order wins, even if it is behind the
+   background, in which case it hides the later sprites too.
+   |------------+--------+----------+--------|
+   | Background | Sprite | Priority | Output |
+   |------------+--------+----------+--------|
+   | 0          | 0      | X        | bg     |
+   | 0          | 1-3    | X        | sprite |
+   | 1-3        | 0      | X        | bg     |
+   | 1-3        | 1-3    | 0        | sprite |
+   | 1-3        | 1-3    | 1        | bg     |
+   |------------+--------+----------+--------|
+
+   Sprite 0 hit is set when an opaque pixel of sprite 0 overlaps an
+   opaque background pixel, except at x=255 or when the background is
+   disabled. Pixels hidden by left column clipping never reach here.
+*/
+func spritePixel(px int, bg byte, palette byte) (byte, byte) {
+	for i := 0; i < sprite_count; i++ {
+		s := sprites[i]
+		offset := px - int(s.x)
+		if offset < 0 || offset >= 8 {
+			continue
+		}
+		bit := 7 - offset
+		pixel := (s.lo>>bit)&0b1 | (s.hi>>bit)&0b1<<1
+		if pixel == 0 {
+			continue
+		}
+		if i == 0 && sprite_zero && GetPpuMask("b") && bg != 0 && px != 255 {
+			setPpuStatus("S", true)
+		}
+		if bg == 0 || s.attribute&0x20 == 0 {
+			return pixel, 4 + s.attribute&0b11
+		}
+		break
+	}
+	return bg, palette
+}
@@ -0,0 +1,133 @@
+package ppu
+
+import "testing"
+
+// Fill OAM with sprites below the screen and place sprites at y
+func setOam(y ...byte) {
+	for i := range OAM {
+		OAM[i] = 0xFF
+	}
+	for n, y := range y {
+		copy(OAM[4*n:], []byte{y, byte(n), 0x00, byte(8 * n)})
+	}
+}
+
+func overflow() bool {
+	return *Ppu_reg.Ppustatus&0x20 != 0
+}
+
+func TestEvaluateSprites(t *testing.T) {
+	initTestPpu(t, nil)
+
+	// Only the first 8 sprites in range are copied, the 9th sets overflow
+	setOam(10, 10, 10, 10, 10, 10, 10, 10, 10, 10)
+	evaluateSprites(12)
+	if secondary_count != 8 {
+		t.Errorf("secondary count %d, want 8", secondary_count)
+	}
+	for i := 0; i < 8; i++ {
+		if secondary_oam[4*i+1] != byte(i) {
+			t.Errorf("slot %d has sprite %d, want %d", i, secondary_oam[4*i+1], i)
+		}
+	}
+	if !overflow() || !sprite_zero_next {
+		t.Errorf("overflow %t, sprite 0 %t, want both set", overflow(), sprite_zero_next)
+	}
+
+	// Sprites out of range leave the rest of secondary OAM at $FF
+	setPpuStatus("O", false)
+	setOam(0xFF, 10, 30, 5)
+	evaluateSprites(12)
+	if secondary_count != 2 || secondary_oam[1] != 1 || secondary_oam[5] != 3 || secondary_oam[8] != 0xFF {
+		t.Errorf("secondary count %d, OAM % X", secondary_count, secondary_oam[:12])
+	}
+	if overflow() || sprite_zero_next {
+		t.Errorf("overflow %t, sprite 0 %t, want both clear", overflow(), sprite_zero_next)
+	}
+
+	// 8x16 sprites are in range for 16 lines
+	*Ppu_reg.Ppuctrl = 0x20
+	setOam(0, 0)
+	evaluateSprites(15)
+	if secondary_count != 2 {
+		t.Errorf("8x16: secondary count %d, want 2", secondary_count)
+	}
+	*Ppu_reg.Ppuctrl = 0x00
+}
+
+/*
+   After the 8th sprite the byte index m increments with n on a miss, so
+   the tile, attribute or X of later sprites is compared as Y
+*/
+func TestSpriteOverflowBug(t *testing.T) {
+	tests := []struct {
+		name string
+		// OAM bytes of sprites 8-10
+		oam  []byte
+		want bool
+	}{
+		{"no more sprites", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, false},
+		{"9th sprite in range", []byte{10, 0xFF, 0xFF, 0xFF}, true},
+		// sprite 8 misses, the tile of sprite 9 is read as Y
+		{"tile read as Y", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 10, 0xFF, 0xFF}, true},
+		// sprite 8 misses, the Y of sprite 9 is skipped
+		{"Y skipped", []byte{0xFF, 0xFF, 0xFF, 0xFF, 10, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, false},
+		// sprites 8-9 miss, the attribute of sprite 10 is read as Y
+		{"attribute read as Y", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 10, 0xFF}, true},
+	}
+	for _, tt := range tests {
+		initTestPpu(t, nil)
+		setOam(10, 10, 10, 10, 10, 10, 10, 10)
+		copy(OAM[4*8:], tt.oam)
+		evaluateSprites(12)
+		if overflow() != tt.want {
+			t.Errorf("%s: overflow %t, want %t", tt.name, overflow(), tt.want)
+		}
+	}
+}
+
+/*
+   Sprite 0 at x with an opaque row over an opaque background, the left
+   column clipping is applied by renderPixel before the hit check
+*/
+func TestSpriteZeroHit(t *testing.T) {
+	tests := []struct {
+		name string
+		mask byte
+		x    byte
+		px   int
+		want bool
+	}{
+		{"hit", 0x1E, 100, 100, true},
+		{"x=255", 0x1E, 248, 255, false},
+		{"x=254", 0x1E, 248, 254, true},
+		{"left column", 0x1E, 0, 0, true},
+		{"background clipped", 0x1C, 0, 7, false},
+		{"sprites clipped", 0x1A, 0, 7, false},
+		{"clipped up to x=7", 0x18, 1, 8, true},
+		{"background disabled", 0x16, 100, 100, false},
+		{"sprites disabled", 0x0E, 100, 100, false},
+	}
+	for _, tt := range tests {
+		initTestPpu(t, nil)
+		*Ppu_reg.Ppumask = tt.mask
+		bg_lo, bg_hi = 0xFFFF, 0x0000
+		sprites[0] = sprite{x: tt.x, lo: 0xFF}
+		sprite_count, sprite_zero = 1, true
+		renderPixel(tt.px)
+		if hit := *Ppu_reg.Ppustatus&0x40 != 0; hit != tt.want {
+			t.Errorf("%s: hit %t, want %t", tt.name, hit, tt.want)
+		}
+	}
+
+	// Other sprites don't set the hit
+	initTestPpu(t, nil)
+	*Ppu_reg.Ppumask = 0x1E
+	bg_lo = 0xFFFF
+	sprites[0] = sprite{x: 100, lo: 0xFF}
+	sprite_count, sprite_zero = 1, false
+	renderPixel(100)
+	if *Ppu_reg.Ppustatus&0x40 != 0 {
+		t.Errorf("hit set without sprite 0")
+	}
+}
@@ -1,5 +1,7 @@
 package ppu
 
+import "github.com/siva0410/emu/casette"
+
 /*
    PPU memory map
    |---------------+-------+------------------------+----------------------------------------|
@@ -15,8 +17,66 @@ package ppu
    | $3F00-$3F1F   | $0020 | Palette RAM indexes    | mapped to the internal palette control |
    | $3F20-$3FFF   | $00E0 | Mirrors of $3F00-$3F1F |                                        |
    |---------------+-------+------------------------+----------------------------------------|
+   Nametables are stored at $2000-$27FF (CIRAM pages 0-1) and $2800-$2FFF
+   (four screen VRAM pages 2-3), the cartridge selects the page of each one.
 */
 var PPU_MEM [0x4000]byte
-var PPU_PTR uint32
 var PPU_MEM_CHK [0x4000]bool
-var CHR_ROM_ADDR uint16 = 0x0000
+
+// Read PPU memory, the address is also reported to the cartridge mapper
+func readPpuMem(addr uint16) byte {
+	addr &= 0x3FFF
+	casette.Cart_mapper.PpuAddr(addr)
+	if addr < 0x2000 {
+		return casette.Cart_mapper.PpuRead(addr)
+	}
+	if addr < 0x3F00 {
+		if nt, ok := casette.Cart_mapper.(casette.NametableMapper); ok {
+			if data, ok := nt.NtRead(addr); ok {
+				return data
+			}
+		}
+		return PPU_MEM[mirrorAddr(addr)]
+	}
+	return PPU_MEM[paletteAddr(addr)]
+}
+
+// Map palette address $3F00-$3FFF to the 32 bytes of palette RAM, entry 0
+// of the sprite palettes ($3F10/$3F14/$3F18/$3F1C) mirrors the one of the
+// background palettes ($3F00/$3F04/$3F08/$3F0C)
+func paletteAddr(addr uint16) uint16 {
+	addr = 0x3F00 | addr&0x1F
+	if addr&0x13 == 0x10 {
+		addr &^= 0x10
+	}
+	return addr
+}
+
+// Map nametable address $2000-$3EFF to the VRAM page selected by the cartridge
+func mirrorAddr(addr uint16) uint16 {
+	pages := casette.Cart_mapper.Mirroring()
+	return 0x2000 + uint16(pages[addr>>10&0b11])*0x0400 + addr&0x03FF
+}
+
+// Write PPU memory, pattern writes go to the CHR RAM of the cartridge and
+// nametable writes may be taken by the cartridge mapper
+func writePpuMem(addr uint16, data byte) {
+	addr &= 0x3FFF
+	casette.Cart_mapper.PpuAddr(addr)
+	if addr < 0x2000 {
+		casette.Cart_mapper.PpuWrite(addr, data)
+		return
+	}
+	if addr < 0x3F00 {
+		if nt, ok := casette.Cart_mapper.(casette.NametableMapper); ok && nt.NtWrite(addr, data) {
+			return
+		}
+		addr = mirrorAddr(addr)
+	} else {
+		// palette RAM is 6 bits wide
+		addr = paletteAddr(addr)
+		data &= 0x3F
+	}
+	PPU_MEM[addr] = data
+	PPU_MEM_CHK[addr] = true
+}
@@ -0,0 +1,143 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/siva0410/emu/casette"
+)
+
+func TestPaletteAddr(t *testing.T) {
+	tests := []struct {
+		addr uint16
+		want uint16
+	}{
+		{0x3F00, 0x3F00},
+		{0x3F04, 0x3F04},
+		{0x3F0F, 0x3F0F},
+		// entry 0 of the sprite palettes mirrors the background palettes
+		{0x3F10, 0x3F00},
+		{0x3F14, 0x3F04},
+		{0x3F18, 0x3F08},
+		{0x3F1C, 0x3F0C},
+		{0x3F11, 0x3F11},
+		{0x3F1F, 0x3F1F},
+		// $3F20-$3FFF mirror $3F00-$3F1F
+		{0x3F20, 0x3F00},
+		{0x3F30, 0x3F00},
+		{0x3F3C, 0x3F0C},
+		{0x3FFF, 0x3F1F},
+		// v is 15 bits
+		{0x7F10, 0x3F00},
+	}
+	for _, tt := range tests {
+		if got := paletteAddr(tt.addr); got != tt.want {
+			t.Errorf("$%04X: got $%04X, want $%04X", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func setAddr(addr uint16) {
+	writeReg(0x2006, byte(addr>>8))
+	writeReg(0x2006, byte(addr))
+}
+
+func TestPpuData(t *testing.T) {
+	initTestPpu(t, nil)
+	setAddr(0x2F10)
+	writeReg(0x2007, 0xAB)
+	setAddr(0x2000)
+	writeReg(0x2007, 0x11)
+	writeReg(0x2007, 0x22)
+
+	// Sprite palette entry 0 writes go to the background palette, 6 bits
+	setAddr(0x3F10)
+	writeReg(0x2007, 0xED)
+	if PPU_MEM[0x3F00] != 0x2D || PPU_MEM[0x3F10] != 0 {
+		t.Errorf("$3F00 $%02X $3F10 $%02X, want $2D and 0", PPU_MEM[0x3F00], PPU_MEM[0x3F10])
+	}
+
+	// Palette reads are not buffered, the buffer gets the nametable byte
+	// under the palette
+	setAddr(0x3F10)
+	if got := readReg(0x2007); got != 0x2D {
+		t.Errorf("$3F10 read $%02X, want $2D", got)
+	}
+	if read_buffer != 0xAB {
+		t.Errorf("buffer $%02X after the palette read, want $AB from $2F10", read_buffer)
+	}
+	if ppu_v != 0x3F11 {
+		t.Errorf("v $%04X after the read, want $3F11", ppu_v)
+	}
+
+	// Other reads return the buffer filled by the previous read
+	setAddr(0x2000)
+	if got := readReg(0x2007); got != 0xAB {
+		t.Errorf("first $2000 read $%02X, want the old buffer $AB", got)
+	}
+	if got := readReg(0x2007); got != 0x11 {
+		t.Errorf("second read $%02X, want $11 from $2000", got)
+	}
+	if got := readReg(0x2007); got != 0x22 {
+		t.Errorf("third read $%02X, want $22 from $2001", got)
+	}
+
+	// Increment by 32 with PPUCTRL I
+	writeReg(0x2000, 0x04)
+	setAddr(0x2000)
+	readReg(0x2007)
+	if ppu_v != 0x2020 {
+		t.Errorf("v $%04X with increment 32, want $2020", ppu_v)
+	}
+}
+
+// Mapper selecting the VRAM page of each nametable
+type mirrorMapper struct {
+	casette.Mapper
+	pages [4]byte
+}
+
+func (m mirrorMapper) Mirroring() [4]byte {
+	return m.pages
+}
+
+func TestNametableMirroring(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages [4]byte
+	}{
+		{"horizontal", [4]byte{0, 0, 1, 1}},
+		{"vertical", [4]byte{0, 1, 0, 1}},
+		{"single A", [4]byte{0, 0, 0, 0}},
+		{"single B", [4]byte{1, 1, 1, 1}},
+		{"four screen", [4]byte{0, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		initTestPpu(t, nil)
+		casette.Cart_mapper = mirrorMapper{casette.Cart_mapper, tt.pages}
+
+		// Nametable n at $2000 and its mirror at $3000
+		for n := uint16(0); n < 4; n++ {
+			setAddr(0x2000 + n*0x0400 + 0x15)
+			writeReg(0x2007, byte(0x10+n))
+		}
+		for n := uint16(0); n < 4; n++ {
+			want := PPU_MEM[0x2000+uint16(tt.pages[n])*0x0400+0x15]
+			setAddr(0x3000 + n*0x0400 + 0x15)
+			readReg(0x2007)
+			if got := readReg(0x2007); got != want {
+				t.Errorf("%s: nametable %d read $%02X, want $%02X from page %d", tt.name, n, got, want, tt.pages[n])
+			}
+		}
+
+		// The last write to each page stays
+		var want [4]byte
+		for n, page := range tt.pages {
+			want[page] = byte(0x10 + n)
+		}
+		for page := uint16(0); page < 4; page++ {
+			if got := PPU_MEM[0x2000+page*0x0400+0x15]; got != want[page] {
+				t.Errorf("%s: page %d $%02X, want $%02X", tt.name, page, got, want[page])
+			}
+		}
+	}
+}
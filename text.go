@@ -0,0 +1,113 @@
+package main
+
+import (
+	"image"
+	"strings"
+
+	"github.com/siva0410/emu/ppu"
+)
+
+/*
+   3x5 font, 15 bits per glyph from the top left pixel
+   Characters are drawn twice the size in 8x12 cells, 32 per line
+*/
+var font = map[rune]uint16{
+	'0':  0b111101101101111,
+	'1':  0b010110010010111,
+	'2':  0b111001111100111,
+	'3':  0b111001111001111,
+	'4':  0b101101111001001,
+	'5':  0b111100111001111,
+	'6':  0b111100111101111,
+	'7':  0b111001001010010,
+	'8':  0b111101111101111,
+	'9':  0b111101111001111,
+	'A':  0b010101111101101,
+	'B':  0b110101110101110,
+	'C':  0b011100100100011,
+	'D':  0b110101101101110,
+	'E':  0b111100110100111,
+	'F':  0b111100110100100,
+	'G':  0b011100101101011,
+	'H':  0b101101111101101,
+	'I':  0b111010010010111,
+	'J':  0b001001001101010,
+	'K':  0b101101110101101,
+	'L':  0b100100100100111,
+	'M':  0b101111111101101,
+	'N':  0b110101101101101,
+	'O':  0b010101101101010,
+	'P':  0b110101110100100,
+	'Q':  0b010101101110011,
+	'R':  0b110101110101101,
+	'S':  0b011100010001110,
+	'T':  0b111010010010010,
+	'U':  0b101101101101111,
+	'V':  0b101101101101010,
+	'W':  0b101101111111101,
+	'X':  0b101101010101101,
+	'Y':  0b101101010010010,
+	'Z':  0b111001010100111,
+	' ':  0b000000000000000,
+	'/':  0b001001010100100,
+	'-':  0b000000111000000,
+	'.':  0b000000000000010,
+	':':  0b000010000010000,
+	',':  0b000000000010100,
+	'\'': 0b010010000000000,
+	'(':  0b001010010010001,
+	')':  0b100010010010100,
+	'!':  0b010010010000010,
+	'&':  0b010101010101011,
+	'?':  0b111001011000010,
+}
+
+const (
+	FONT_SCALE  = 2
+	CELL_WIDTH  = 4 * FONT_SCALE
+	CELL_HEIGHT = 6 * FONT_SCALE
+
+	// Palette entries of black and white
+	TEXT_BACKGROUND = 0x0F
+	TEXT_FOREGROUND = 0x30
+)
+
+// Draw lines of text on a black screen in frame, used instead of the PPU
+// output by the NSF player
+func drawText(frame *image.RGBA, lines []string) {
+	fillRect(frame, frame.Bounds(), TEXT_BACKGROUND)
+
+	for y, text := range lines {
+		for x, c := range strings.ToUpper(text) {
+			drawChar(frame, CELL_WIDTH*(x+1), CELL_HEIGHT*(y+1), c)
+		}
+	}
+}
+
+func drawChar(frame *image.RGBA, left int, top int, c rune) {
+	glyph, ok := font[c]
+	if !ok {
+		glyph = font['?']
+	}
+	for i := 0; i < 15; i++ {
+		if glyph>>(14-i)&0b1 == 0 {
+			continue
+		}
+		x := left + i%3*FONT_SCALE
+		y := top + i/3*FONT_SCALE
+		fillRect(frame, image.Rect(x, y, x+FONT_SCALE, y+FONT_SCALE), TEXT_FOREGROUND)
+	}
+}
+
+// Fill rect with the color of a palette entry, clipped to the frame
+func fillRect(frame *image.RGBA, rect image.Rectangle, entry byte) {
+	color := ppu.EmphasisTable[0][entry]
+	rect = rect.Intersect(frame.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := frame.PixOffset(x, y)
+			copy(frame.Pix[i:i+3], color[:])
+			frame.Pix[i+3] = 0xFF
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package window
+
+import (
+	"image"
+
+	"github.com/go-gl/gl/v4.6-core/gl"
+)
+
+var (
+	// Quad covering the window, position and texture coordinate of each
+	// corner, the top of the frame is texture row 0
+	vertexQuad = []float32{
+		-1, 1, 0, 0,
+		-1, -1, 0, 1,
+		1, -1, 1, 1,
+		1, 1, 1, 0,
+	}
+)
+
+// Vertex array of the quad and the texture holding the frame, created by
+// the first Draw
+var quad uint32
+var texture uint32
+
+// Draw the frame produced by the PPU, only the texture is uploaded each
+// frame
+func Draw(frame *image.RGBA) {
+	if quad == 0 {
+		quad = makeVao(vertexQuad)
+		texture = makeTexture()
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, texture)
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(frame.Stride/4))
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, columns, rows, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(frame.Pix))
+
+	gl.BindVertexArray(quad)
+	gl.DrawArrays(gl.TRIANGLE_FAN, 0, 4)
+}
+
+// makeVao initializes and returns a vertex array from the points provided.
+func makeVao(points []float32) uint32 {
+	var vbo uint32
+	gl.GenBuffers(1, &vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, 4*len(points), gl.Ptr(points), gl.STATIC_DRAW)
+
+	var vao uint32
+	gl.GenVertexArrays(1, &vao)
+	gl.BindVertexArray(vao)
+	gl.EnableVertexAttribArray(0)
+	gl.EnableVertexAttribArray(1)
+	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+	gl.VertexAttribPointer(0, 2, gl.FLOAT, false, 4*4, gl.PtrOffset(0))
+	gl.VertexAttribPointer(1, 2, gl.FLOAT, false, 4*4, gl.PtrOffset(2*4))
+
+	return vao
+}
+
+// makeTexture allocates an RGBA texture of the screen size, pixels are
+// scaled to the window without filtering
+func makeTexture() uint32 {
+	var tex uint32
+	gl.GenTextures(1, &tex)
+	gl.BindTexture(gl.TEXTURE_2D, tex)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA8, columns, rows, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+
+	return tex
+}
@@ -18,23 +18,23 @@ const (
 
 	vertexShaderSource = `
 		#version 410 core
-		layout(location = 0) in vec3 vp;
-                layout(location = 1) in vec3 vc;
-                out vec4 vColor;
+		layout(location = 0) in vec2 vp;
+		layout(location = 1) in vec2 vt;
+		out vec2 tex_coord;
 
 		void main() {
-			gl_Position = vec4(vp, 1.0);
-                        vColor = vec4(vc, 1.0);
-                        // vColor = vec4(1.0, 1.0, 1.0, 1.0);
+			gl_Position = vec4(vp, 0.0, 1.0);
+			tex_coord = vt;
 		}
 	` + "\x00"
 
 	fragmentShaderSource = `
 		#version 410 core
-                in vec4 vColor;
+		uniform sampler2D frame;
+		in vec2 tex_coord;
 		out vec4 frag_color;
 		void main() {
-			frag_color = vColor;
+			frag_color = texture(frame, tex_coord);
 		}
 	` + "\x00"
 )